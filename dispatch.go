@@ -0,0 +1,151 @@
+// dispatch decides how incoming messages are scheduled: document synchronization
+// notifications are applied strictly in arrival order per document, while queries run
+// concurrently against a snapshot of the document taken when they arrived.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// documentParams extracts the document URI shared by all text document messages
+type documentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// documentSnapshot holds the buffer state of a document at the time a query arrived
+type documentSnapshot struct {
+	path  string
+	lines []string
+	ok    bool
+}
+
+type documentSnapshotKey struct{}
+
+// dispatcher schedules incoming messages for a server
+type dispatcher struct {
+	server *Server
+	mu     sync.Mutex
+	tails  map[string]chan struct{} // last queued operation per document URI
+}
+
+// newDispatcher creates a dispatcher for the given server.
+func newDispatcher(server *Server) *dispatcher {
+	return &dispatcher{
+		server: server,
+		tails:  make(map[string]chan struct{}),
+	}
+}
+
+// isDocumentSync reports whether a method mutates document state and must be applied in order.
+func isDocumentSync(method string) bool {
+	switch method {
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose":
+		return true
+	}
+	return false
+}
+
+// dispatch schedules a single incoming message. The lifecycle check happens here,
+// in arrival order, so messages sent before shutdown are still answered.
+func (d *dispatcher) dispatch(req RPCRequest) {
+	if !checkInitializedOrFail(req.ID, d.server, req.Method) {
+		// Server not initialized and request is not allowed.
+		return
+	}
+
+	var params documentParams
+	if len(req.Params) > 0 {
+		// Messages without a text document simply have an empty URI
+		_ = json.Unmarshal(req.Params, &params)
+	}
+	uri := params.TextDocument.URI
+
+	// Track requests (but not notifications) from their arrival, so a cancellation
+	// also reaches requests still waiting in a document queue
+	requestCtx := context.Background()
+	done := func() {}
+	if len(req.ID) > 0 {
+		requestCtx, done = d.server.requests.begin(requestCtx, req.ID)
+	}
+
+	// shutdown waits for everything dispatched before it, so it isn't counted itself
+	counted := req.Method != "shutdown" && req.Method != "exit"
+	if counted {
+		d.server.pending.Add(1)
+	}
+	run := func(ctx context.Context) {
+		defer func() {
+			done()
+			if counted {
+				d.server.pending.Done()
+			}
+		}()
+		if isCancelled(ctx) {
+			d.server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+			return
+		}
+		handleRequest(ctx, d.server, req)
+	}
+
+	switch {
+	case uri == "":
+		go run(requestCtx)
+	case isDocumentSync(req.Method):
+		d.enqueue(uri, func() {
+			run(requestCtx)
+		})
+	default:
+		// Take the snapshot in document order so later changes are not visible,
+		// then answer the query without holding up the queue.
+		d.enqueue(uri, func() {
+			ctx := d.server.snapshotDocument(requestCtx, uri)
+			go run(ctx)
+		})
+	}
+}
+
+// enqueue runs fn once all previously queued operations for the document have finished.
+func (d *dispatcher) enqueue(uri string, fn func()) {
+	done := make(chan struct{})
+
+	d.mu.Lock()
+	prev := d.tails[uri]
+	d.tails[uri] = done
+	d.mu.Unlock()
+
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+		fn()
+		close(done)
+
+		d.mu.Lock()
+		if d.tails[uri] == done {
+			delete(d.tails, uri)
+		}
+		d.mu.Unlock()
+	}()
+}
+
+// snapshotDocument records the current cached content of a document in the request context.
+func (s *Server) snapshotDocument(ctx context.Context, uri string) context.Context {
+	filePath := uriToPath(uri)
+	lines, ok := s.cache.GetCachedContent(filePath)
+	return context.WithValue(ctx, documentSnapshotKey{}, documentSnapshot{
+		path:  filePath,
+		lines: lines,
+		ok:    ok,
+	})
+}
+
+// openDocumentLines returns the content of an open document, preferring the snapshot
+// taken when the request arrived over the live cache.
+func (s *Server) openDocumentLines(ctx context.Context, filePath string) ([]string, bool) {
+	if snapshot, ok := ctx.Value(documentSnapshotKey{}).(documentSnapshot); ok && snapshot.path == filePath {
+		return snapshot.lines, snapshot.ok
+	}
+	return s.cache.GetCachedContent(filePath)
+}
@@ -0,0 +1,354 @@
+// doc_comment extracts the documentation of a definition from its source: the comment block
+// right above it, or a docstring right below it for languages that document that way.
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxDocCommentLines bounds how far a comment block or docstring is followed
+const maxDocCommentLines = 200
+
+// commentStyle describes how a language writes documentation comments
+type commentStyle struct {
+	linePrefixes []string // line comment markers, longest first
+	blocks       bool     // whether /* */ block comments are used
+	docstrings   bool     // Python style docstrings below the definition
+	moduleDocs   bool     // Elixir style @doc and @moduledoc attributes
+}
+
+var (
+	cStyle    = commentStyle{linePrefixes: []string{"///", "//!", "//"}, blocks: true}
+	hashStyle = commentStyle{linePrefixes: []string{"##", "#"}}
+	dashStyle = commentStyle{linePrefixes: []string{"---", "--"}}
+)
+
+// commentStyles maps ctags language names to their comment style
+var commentStyles = map[string]commentStyle{
+	"C":          cStyle,
+	"C++":        cStyle,
+	"C#":         cStyle,
+	"CUDA":       cStyle,
+	"D":          cStyle,
+	"Go":         cStyle,
+	"Java":       cStyle,
+	"JavaScript": cStyle,
+	"Kotlin":     cStyle,
+	"ObjectiveC": cStyle,
+	"PHP":        {linePrefixes: []string{"///", "//", "#"}, blocks: true},
+	"Rust":       cStyle,
+	"Scala":      cStyle,
+	"Swift":      cStyle,
+	"TypeScript": cStyle,
+	"Zig":        cStyle,
+	"CSS":        {blocks: true},
+	"SCSS":       cStyle,
+
+	"CMake":      hashStyle,
+	"Dockerfile": hashStyle,
+	"Julia":      hashStyle,
+	"Make":       hashStyle,
+	"Perl":       hashStyle,
+	"PowerShell": hashStyle,
+	"R":          {linePrefixes: []string{"#'", "##", "#"}},
+	"Ruby":       hashStyle,
+	"Sh":         hashStyle,
+	"Tcl":        hashStyle,
+	"Yaml":       hashStyle,
+	"Python":     {linePrefixes: []string{"##", "#"}, docstrings: true},
+	"Elixir":     {linePrefixes: []string{"##", "#"}, moduleDocs: true},
+
+	"Ada":     dashStyle,
+	"Elm":     {linePrefixes: []string{"--|", "--"}},
+	"Haskell": {linePrefixes: []string{"-- |", "--"}},
+	"Lua":     dashStyle,
+	"SQL":     dashStyle,
+	"VHDL":    dashStyle,
+
+	"Clojure": {linePrefixes: []string{";;;", ";;", ";"}},
+	"Lisp":    {linePrefixes: []string{";;;", ";;", ";"}},
+	"Erlang":  {linePrefixes: []string{"%%%", "%%", "%"}},
+	"Tex":     {linePrefixes: []string{"%"}},
+	"Vim":     {linePrefixes: []string{`"`}},
+}
+
+// fallbackStyle is used for languages without a known comment style
+var fallbackStyle = commentStyle{linePrefixes: []string{"///", "//", "#"}, blocks: true}
+
+// docComment returns the documentation of the definition on line (1-based) of a file in
+// a ctags language, with comment markers and common indentation removed. It returns an
+// empty string if the definition is undocumented.
+func docComment(lines []string, line int, language string) string {
+	idx := line - 1
+	if idx < 0 || idx >= len(lines) {
+		return ""
+	}
+
+	style, ok := commentStyles[language]
+	if !ok {
+		style = fallbackStyle
+	}
+
+	if style.docstrings {
+		if doc := docstringBelow(lines, idx); doc != nil {
+			return formatDoc(doc, true)
+		}
+	}
+	if style.moduleDocs {
+		if doc, found := moduleDocAbove(lines, idx); found {
+			return formatDoc(doc, true)
+		}
+		if strings.HasPrefix(strings.TrimSpace(lines[idx]), "defmodule") {
+			if doc, found := moduleDocBelow(lines, idx); found {
+				return formatDoc(doc, true)
+			}
+		}
+	}
+	return formatDoc(commentAbove(lines, idx, style), false)
+}
+
+// commentAbove collects the comment block that ends right above line idx, skipping
+// decorators and annotations in between.
+func commentAbove(lines []string, idx int, style commentStyle) []string {
+	end := idx - 1
+	for end >= 0 && isAnnotation(strings.TrimSpace(lines[end])) && idx-end < maxDocCommentLines {
+		end--
+	}
+	if end < 0 {
+		return nil
+	}
+
+	last := strings.TrimSpace(lines[end])
+	if style.blocks && strings.HasSuffix(last, "*/") {
+		return blockCommentEndingAt(lines, end)
+	}
+
+	var doc []string
+	for i := end; i >= 0 && end-i < maxDocCommentLines; i-- {
+		text, ok := stripLineComment(strings.TrimSpace(lines[i]), style.linePrefixes)
+		if !ok {
+			break
+		}
+		doc = append(doc, text)
+	}
+	slices.Reverse(doc)
+	return doc
+}
+
+// isAnnotation reports whether a trimmed line is a decorator or annotation such as
+// @Override or #[derive(Debug)], which sit between a doc comment and its definition.
+func isAnnotation(line string) bool {
+	if strings.HasPrefix(line, "#[") || strings.HasPrefix(line, "#![") {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(line, "@"); ok && rest != "" {
+		// Elixir's @doc is documentation, not an annotation
+		return !strings.HasPrefix(rest, "doc") && !strings.HasPrefix(rest, "moduledoc")
+	}
+	return false
+}
+
+// stripLineComment removes the first matching line comment marker from a trimmed line.
+func stripLineComment(line string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(line, prefix); ok {
+			return strings.TrimPrefix(rest, " "), true
+		}
+	}
+	return "", false
+}
+
+// blockCommentEndingAt collects the /* */ comment whose closing marker is on line end.
+func blockCommentEndingAt(lines []string, end int) []string {
+	start := end
+	for start >= 0 && end-start < maxDocCommentLines && !strings.Contains(lines[start], "/*") {
+		start--
+	}
+	if start < 0 || end-start >= maxDocCommentLines {
+		return nil
+	}
+
+	doc := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		text := strings.TrimSpace(lines[i])
+		if i == start {
+			_, text, _ = strings.Cut(text, "/*")
+			text = strings.TrimLeft(text, "*!")
+		}
+		if i == end {
+			text, _, _ = strings.Cut(text, "*/")
+		}
+		if i != start {
+			// Continuation lines usually start with " * "
+			if rest, ok := strings.CutPrefix(text, "*"); ok {
+				text = rest
+			}
+		}
+		doc = append(doc, strings.TrimPrefix(strings.TrimRight(text, " \t"), " "))
+	}
+	return doc
+}
+
+// docstringBelow returns the Python docstring of the definition starting on line idx, or
+// nil if the body doesn't start with one.
+func docstringBelow(lines []string, idx int) []string {
+	definition := strings.TrimSpace(lines[idx])
+	if !strings.HasPrefix(definition, "def ") && !strings.HasPrefix(definition, "async def ") && !strings.HasPrefix(definition, "class ") {
+		return nil
+	}
+
+	// The signature may span several lines and ends with a colon
+	body := -1
+	for i := idx; i < len(lines) && i-idx < maxDocCommentLines; i++ {
+		if strings.HasSuffix(strings.TrimSpace(stripTrailingComment(lines[i])), ":") {
+			body = i + 1
+			break
+		}
+	}
+	if body < 0 {
+		return nil
+	}
+	for body < len(lines) && strings.TrimSpace(lines[body]) == "" {
+		body++
+	}
+	if body >= len(lines) {
+		return nil
+	}
+
+	// Skip string prefixes such as r or u
+	text := strings.TrimSpace(lines[body])
+	if i := strings.IndexAny(text, `"'`); i > 0 && i <= 2 && strings.Trim(text[:i], "rRuUbB") == "" {
+		text = text[i:]
+	}
+	doc, _ := quotedBlock(lines, body, text)
+	return doc
+}
+
+// stripTrailingComment removes a '#' comment from the end of a line of Python.
+func stripTrailingComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 && !strings.ContainsAny(line[:i], `"'`) {
+		return line[:i]
+	}
+	return line
+}
+
+// moduleDocAbove returns the Elixir @doc attribute above the definition on line idx,
+// skipping other attributes such as @spec. @doc false counts as found without content.
+func moduleDocAbove(lines []string, idx int) ([]string, bool) {
+	for i := idx - 1; i >= 0 && idx-i < maxDocCommentLines; i-- {
+		// Jump over heredocs to the line that opened them
+		if start := heredocStart(lines, i); start >= 0 {
+			i = start
+		}
+
+		line := strings.TrimSpace(lines[i])
+		if rest, ok := strings.CutPrefix(line, "@doc"); ok && (rest == "" || rest[0] == ' ') {
+			return quotedBlock(lines, i, strings.TrimSpace(rest))
+		}
+		if !strings.HasPrefix(line, "@") && !strings.HasPrefix(line, "#") {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// heredocStart returns the line opening the heredoc that is closed on line end, or -1.
+func heredocStart(lines []string, end int) int {
+	if strings.TrimSpace(lines[end]) != `"""` {
+		return -1
+	}
+	for i := end - 1; i >= 0 && end-i < maxDocCommentLines; i-- {
+		if strings.HasSuffix(strings.TrimSpace(lines[i]), `"""`) {
+			return i
+		}
+	}
+	return -1
+}
+
+// moduleDocBelow returns the Elixir @moduledoc attribute of the module defined on line idx.
+func moduleDocBelow(lines []string, idx int) ([]string, bool) {
+	for i := idx + 1; i < len(lines) && i-idx < maxDocCommentLines; i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "@moduledoc"); ok {
+			return quotedBlock(lines, i, strings.TrimSpace(rest))
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// quotedBlock reads the string literal that starts at text, the remainder of line start.
+// It handles triple-quoted strings spanning several lines and single-line strings, and
+// reports false if text doesn't start a string.
+func quotedBlock(lines []string, start int, text string) ([]string, bool) {
+	for _, quote := range []string{`"""`, `'''`, `~S"""`, `~s"""`} {
+		rest, ok := strings.CutPrefix(text, quote)
+		if !ok {
+			continue
+		}
+		closing := quote[len(quote)-3:]
+		if before, _, ok := strings.Cut(rest, closing); ok {
+			return []string{before}, true
+		}
+
+		doc := []string{rest}
+		for i := start + 1; i < len(lines) && i-start < maxDocCommentLines; i++ {
+			if before, _, ok := strings.Cut(lines[i], closing); ok {
+				return append(doc, before), true
+			}
+			doc = append(doc, lines[i])
+		}
+		return nil, false
+	}
+
+	for _, quote := range []string{`"`, `'`} {
+		if rest, ok := strings.CutPrefix(text, quote); ok {
+			before, _, _ := strings.Cut(rest, quote)
+			return []string{before}, true
+		}
+	}
+	return nil, text == "false"
+}
+
+// formatDoc removes the common indentation and surrounding blank lines of doc lines.
+// In quoted docs the first line follows the opening quotes and is not indented.
+func formatDoc(doc []string, quoted bool) string {
+	for len(doc) > 0 && strings.TrimSpace(doc[0]) == "" {
+		doc = doc[1:]
+		quoted = false // The text starts on a line of its own
+	}
+	for len(doc) > 0 && strings.TrimSpace(doc[len(doc)-1]) == "" {
+		doc = doc[:len(doc)-1]
+	}
+	if len(doc) == 0 {
+		return ""
+	}
+
+	indent := -1
+	for i, line := range doc {
+		if strings.TrimSpace(line) == "" || (quoted && i == 0 && len(doc) > 1) {
+			continue
+		}
+		width := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || width < indent {
+			indent = width
+		}
+	}
+
+	out := make([]string, len(doc))
+	for i, line := range doc {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case quoted && i == 0:
+			line = strings.TrimLeft(line, " \t")
+		case indent > 0 && len(line) >= indent:
+			line = line[indent:]
+		}
+		out[i] = line
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		source   string
+		line     int
+		want     string
+	}{
+		{
+			"line comments",
+			"Go",
+			"package main\n\n// Add returns the sum\n// of a and b.\nfunc Add(a, b int) int {",
+			5,
+			"Add returns the sum\nof a and b.",
+		},
+		{
+			"blank line ends the block",
+			"Go",
+			"// Unrelated\n\nfunc Add() {}",
+			3,
+			"",
+		},
+		{
+			"undocumented",
+			"Go",
+			"x := 1\nfunc Add() {}",
+			2,
+			"",
+		},
+		{
+			"doc comment markers",
+			"Rust",
+			"/// Adds two numbers.\n///\n///   Indented.\nfn add() {}",
+			4,
+			"Adds two numbers.\n\n  Indented.",
+		},
+		{
+			"javadoc block",
+			"Java",
+			"/**\n * Returns the name.\n *\n * @return the name\n */\npublic String name() {",
+			6,
+			"Returns the name.\n\n@return the name",
+		},
+		{
+			"single-line block",
+			"C",
+			"/* Frees the buffer. */\nvoid release(void);",
+			2,
+			"Frees the buffer.",
+		},
+		{
+			"hash comments",
+			"Ruby",
+			"  # Barks loudly.\n  # Twice.\n  def bark",
+			3,
+			"Barks loudly.\nTwice.",
+		},
+		{
+			"dash comments",
+			"Lua",
+			"--- Greets a person.\nfunction greet(name)",
+			2,
+			"Greets a person.",
+		},
+		{
+			"unknown language",
+			"Brainfuck",
+			"# Fallback style\nthing",
+			2,
+			"Fallback style",
+		},
+		{
+			"java annotation",
+			"Java",
+			"  /** Compares by name. */\n  @Override\n  @SuppressWarnings(\"unchecked\")\n  public int compareTo(Item other) {",
+			4,
+			"Compares by name.",
+		},
+		{
+			"rust attribute",
+			"Rust",
+			"/// A point.\n#[derive(Debug, Clone)]\nstruct Point {",
+			3,
+			"A point.",
+		},
+		{
+			"python decorator",
+			"Python",
+			"# Cached lookup.\n@functools.cache\ndef lookup(key):\n    return key",
+			3,
+			"Cached lookup.",
+		},
+		{
+			"python docstring",
+			"Python",
+			"def greet(name):\n    \"\"\"Greets a person.\n\n    Prints the name.\n    \"\"\"\n    print(name)",
+			1,
+			"Greets a person.\n\nPrints the name.",
+		},
+		{
+			"python one-line docstring",
+			"Python",
+			"class Point:\n    '''A point.'''",
+			1,
+			"A point.",
+		},
+		{
+			"python multi-line signature",
+			"Python",
+			"def add(\n    a,  # first\n    b,\n):  # sum\n    r\"\"\"Adds a and b.\"\"\"",
+			1,
+			"Adds a and b.",
+		},
+		{
+			"python without docstring",
+			"Python",
+			"# Comment above.\ndef add(a, b):\n    return a + b",
+			2,
+			"Comment above.",
+		},
+		{
+			"elixir doc heredoc",
+			"Elixir",
+			"  @doc \"\"\"\n  Greets a person.\n\n      iex> greet(\"Jo\")\n  \"\"\"\n  @spec greet(String.t()) :: :ok\n  def greet(name) do",
+			7,
+			"Greets a person.\n\n    iex> greet(\"Jo\")",
+		},
+		{
+			"elixir doc string",
+			"Elixir",
+			"  @doc \"Adds two numbers.\"\n  def add(a, b), do: a + b",
+			2,
+			"Adds two numbers.",
+		},
+		{
+			"elixir doc false",
+			"Elixir",
+			"  # Internal.\n  @doc false\n  def helper do",
+			3,
+			"",
+		},
+		{
+			"elixir moduledoc",
+			"Elixir",
+			"defmodule Greeter do\n  @moduledoc \"\"\"\n  Greets people.\n  \"\"\"",
+			1,
+			"Greets people.",
+		},
+		{
+			"elixir sigil moduledoc",
+			"Elixir",
+			"defmodule Greeter do\n\n  @moduledoc ~S\"\"\"\n  Uses \\n literally.\n  \"\"\"",
+			1,
+			"Uses \\n literally.",
+		},
+		{"line out of range", "Go", "func Add() {}", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(tt.source, "\n")
+			if got := docComment(lines, tt.line, tt.language); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDoc(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    []string
+		quoted bool
+		want   string
+	}{
+		{"empty", nil, false, ""},
+		{"blank lines", []string{"", "  ", ""}, false, ""},
+		{"common indentation", []string{"    a", "      b", "", "    c"}, false, "a\n  b\n\nc"},
+		{"trailing whitespace", []string{"a  ", "b\t\r"}, false, "a\nb"},
+		{"quoted first line", []string{"Summary.", "    Details", "      more"}, true, "Summary.\nDetails\n  more"},
+		{"quoted text on its own line", []string{"", "    Summary.", "      Details"}, true, "Summary.\n  Details"},
+		{"quoted single line", []string{"  Summary.  "}, true, "Summary."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDoc(tt.doc, tt.quoted); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
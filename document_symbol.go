@@ -0,0 +1,155 @@
+// document_symbol builds the hierarchical outline of a document for clients that support
+// 'DocumentSymbol' results. Entries are nested under the entry their scope names, and
+// their ranges span from the tag line to the end line ctags reported.
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// TextDocumentClientCapabilities defines the text document specific client capabilities
+type TextDocumentClientCapabilities struct {
+	DocumentSymbol *DocumentSymbolClientCapabilities `json:"documentSymbol,omitempty"`
+}
+
+// DocumentSymbolClientCapabilities defines the client's support for document symbols
+type DocumentSymbolClientCapabilities struct {
+	HierarchicalDocumentSymbolSupport bool `json:"hierarchicalDocumentSymbolSupport,omitempty"`
+}
+
+// DocumentSymbol represents a symbol of a document outline with its nested symbols
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// symbolNode is a document symbol while the outline is being built
+type symbolNode struct {
+	entry    TagEntry
+	symbol   DocumentSymbol
+	children []*symbolNode
+}
+
+// supportsHierarchicalSymbols reports whether the client accepts DocumentSymbol results.
+func (s *Server) supportsHierarchicalSymbols() bool {
+	textDocument := s.capabilities.TextDocument
+	return textDocument != nil && textDocument.DocumentSymbol != nil && textDocument.DocumentSymbol.HierarchicalDocumentSymbolSupport
+}
+
+// documentSymbolTree nests the entries of a document by scope. Entries whose scope can't
+// be found in the document stay at the top level.
+func documentSymbolTree(entries []TagEntry, lines []string, encoding string) []DocumentSymbol {
+	nodes := make([]*symbolNode, 0, len(entries))
+	for _, entry := range entries {
+		kind, err := GetLSPSymbolKind(entry.Kind)
+		if err != nil {
+			// Skip symbols with unknown kinds
+			continue
+		}
+		selection := findSymbolRangeInFile(lines, entry.Name, entry.Line, encoding)
+		nodes = append(nodes, &symbolNode{
+			entry: entry,
+			symbol: DocumentSymbol{
+				Name:           entry.Name,
+				Detail:         entry.Signature,
+				Kind:           kind,
+				Range:          entryRange(entry, lines, selection, encoding),
+				SelectionRange: selection,
+			},
+		})
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].entry.Line < nodes[j].entry.Line
+	})
+
+	var roots []*symbolNode
+	for i, node := range nodes {
+		if parent := scopeParent(nodes[:i], node.entry); parent != nil {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	symbols := make([]DocumentSymbol, 0, len(roots))
+	for _, root := range roots {
+		symbols = append(symbols, root.build())
+	}
+	return symbols
+}
+
+// scopeParent returns the innermost earlier node that an entry's scope refers to, or nil.
+func scopeParent(earlier []*symbolNode, entry TagEntry) *symbolNode {
+	if entry.Scope == "" {
+		return nil
+	}
+	name := lastScopeComponent(entry.Scope)
+
+	var fallback *symbolNode
+	for i := len(earlier) - 1; i >= 0; i-- {
+		candidate := earlier[i]
+		if candidate.entry.Name != name {
+			continue
+		}
+		if entry.ScopeKind != "" && candidate.entry.Kind != entry.ScopeKind {
+			continue
+		}
+		if candidate.entry.End >= entry.Line {
+			return candidate
+		}
+		// Without an end line, the closest preceding candidate is the best guess
+		if candidate.entry.End == 0 && fallback == nil {
+			fallback = candidate
+		}
+	}
+	return fallback
+}
+
+// lastScopeComponent returns the innermost name of a qualified scope such as
+// "Outer.Inner" or "ns::Class".
+func lastScopeComponent(scope string) string {
+	if i := strings.LastIndex(scope, "::"); i >= 0 {
+		scope = scope[i+2:]
+	}
+	if i := strings.LastIndexAny(scope, "./"); i >= 0 {
+		scope = scope[i+1:]
+	}
+	return scope
+}
+
+// entryRange returns the full range of an entry, from the start of its line to the end of
+// its end line. Without an end line the range covers the selection's line.
+func entryRange(entry TagEntry, lines []string, selection Range, encoding string) Range {
+	start := Position{Line: selection.Start.Line, Character: 0}
+	end := selection.End
+
+	if endIdx := entry.End - 1; endIdx > selection.Start.Line && endIdx < len(lines) {
+		end = Position{Line: endIdx, Character: byteOffsetToCharacter(lines[endIdx], len(lines[endIdx]), encoding)}
+	} else if idx := selection.Start.Line; idx >= 0 && idx < len(lines) {
+		end = Position{Line: idx, Character: byteOffsetToCharacter(lines[idx], len(lines[idx]), encoding)}
+	}
+	return Range{Start: start, End: end}
+}
+
+// build converts a node to a DocumentSymbol, widening its range to cover its children.
+func (n *symbolNode) build() DocumentSymbol {
+	symbol := n.symbol
+	for _, child := range n.children {
+		childSymbol := child.build()
+		if positionBefore(symbol.Range.End, childSymbol.Range.End) {
+			symbol.Range.End = childSymbol.Range.End
+		}
+		symbol.Children = append(symbol.Children, childSymbol)
+	}
+	return symbol
+}
+
+// positionBefore reports whether a comes before b
+func positionBefore(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
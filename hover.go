@@ -0,0 +1,163 @@
+// hover describes the definitions of the symbol under the cursor in Markdown, using the
+// fields ctags recorded for each tag.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hover represents the result of the 'textDocument/hover' request
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+}
+
+// handleHover processes the 'textDocument/hover' request
+func handleHover(ctx context.Context, server *Server, req RPCRequest) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	filePath := uriToPath(params.TextDocument.URI)
+	symbol, err := server.getCurrentWord(ctx, filePath, params.Position)
+	if err != nil {
+		server.out.sendResult(req.ID, nil) // No symbol found at position
+		return
+	}
+
+	var matches []workspaceEntry
+	err = server.forEachNamed(ctx, symbol, func(workspace *Workspace, entry TagEntry) {
+		matches = append(matches, workspaceEntry{workspace, entry})
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	var sections []string
+	for _, m := range matches {
+		sections = append(sections, server.describeEntry(m.workspace, m.entry))
+	}
+
+	if len(sections) == 0 {
+		server.out.sendResult(req.ID, nil)
+		return
+	}
+	server.out.sendResult(req.ID, Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: strings.Join(sections, "\n\n---\n\n"),
+		},
+	})
+}
+
+// describeEntry renders a tag as Markdown: its kind and scope, the declaration, the
+// signature and type if known, and where it is defined.
+func (s *Server) describeEntry(workspace *Workspace, entry TagEntry) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s `%s`", entry.Kind, entry.Name)
+	if entry.Scope != "" {
+		if entry.ScopeKind != "" {
+			fmt.Fprintf(&b, " in %s `%s`", entry.ScopeKind, entry.Scope)
+		} else {
+			fmt.Fprintf(&b, " in `%s`", entry.Scope)
+		}
+	}
+	b.WriteString("\n")
+
+	if declaration := s.declarationLine(workspace, entry); declaration != "" {
+		fmt.Fprintf(&b, "\n```%s\n%s\n```\n", fenceLanguage(entry.Language), declaration)
+	}
+
+	if doc := s.entryDoc(workspace, entry); doc != "" {
+		fmt.Fprintf(&b, "\n%s\n", doc)
+	}
+
+	var details []string
+	if entry.Signature != "" {
+		details = append(details, fmt.Sprintf("Signature: `%s`", entry.Signature))
+	}
+	if typeName := typeRefName(entry.TypeRef); typeName != "" {
+		details = append(details, fmt.Sprintf("Type: `%s`", typeName))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", strings.Join(details, " · "))
+	}
+
+	fmt.Fprintf(&b, "\n%s:%d", entry.Path, entry.Line)
+	return b.String()
+}
+
+// entryDoc returns the doc comment of a tag, read from its file.
+func (s *Server) entryDoc(workspace *Workspace, entry TagEntry) string {
+	lines, err := s.documentLines(workspace.absolutePath(entry.Path))
+	if err != nil {
+		return ""
+	}
+	return docComment(lines, entry.Line, entry.Language)
+}
+
+// declarationLine returns the line that declares a tag, from its search pattern or, for
+// tags located by line number, from the source file.
+func (s *Server) declarationLine(workspace *Workspace, entry TagEntry) string {
+	if line, ok := patternLine(entry.Pattern); ok {
+		return strings.TrimSpace(line)
+	}
+
+	lines, err := s.cache.GetOrLoadFileContent(workspace.absolutePath(entry.Path))
+	if err != nil || entry.Line < 1 || entry.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[entry.Line-1])
+}
+
+// patternLine extracts the source line from a ctags search pattern like /^func main() {$/.
+// It reports false for line number patterns.
+func patternLine(pattern string) (string, bool) {
+	if _, err := strconv.Atoi(pattern); err == nil || len(pattern) < 2 {
+		return "", false
+	}
+
+	delimiter := pattern[0]
+	if delimiter != '/' && delimiter != '?' || pattern[len(pattern)-1] != delimiter {
+		return "", false
+	}
+	line := pattern[1 : len(pattern)-1]
+	line = strings.TrimPrefix(line, "^")
+	line = strings.TrimSuffix(line, "$")
+
+	// ctags escapes the delimiter and backslashes
+	line = strings.ReplaceAll(line, `\`+string(delimiter), string(delimiter))
+	line = strings.ReplaceAll(line, `\\`, `\`)
+	return line, true
+}
+
+// typeRefName returns the type name of a ctags typeref such as "typename:int".
+func typeRefName(typeRef string) string {
+	if _, name, ok := strings.Cut(typeRef, ":"); ok {
+		return name
+	}
+	return typeRef
+}
+
+// fenceLanguage returns the Markdown code fence label for a ctags language, which is its
+// LSP language identifier where one is known.
+func fenceLanguage(language string) string {
+	label := ""
+	for languageID, ctagsLanguage := range ctagsLanguages {
+		// Prefer "javascript" over "javascriptreact" and the like
+		if ctagsLanguage == language && (label == "" || len(languageID) < len(label)) {
+			label = languageID
+		}
+	}
+	if label == "" {
+		label = strings.ToLower(language)
+	}
+	return label
+}
@@ -0,0 +1,229 @@
+// index_cache persists the tag entries of a workspace between runs, so a restart only
+// runs ctags on files that changed. Files are matched by size and modification time,
+// falling back to a content hash when only the modification time differs.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// indexCacheVersion is bumped whenever the cache layout or the meaning of entries changes
+const indexCacheVersion = 2
+
+// indexCache is the on-disk cache of a workspace index
+type indexCache struct {
+	Version      int                   `json:"version"`
+	Root         string                `json:"root"`
+	Settings     string                `json:"settings"`
+	CtagsVersion string                `json:"ctagsVersion"`
+	Files        map[string]cachedFile `json:"files"`
+
+	dirty bool // whether the cache differs from the workspace and must be written
+}
+
+// cachedFile holds the entries of a file along with what identifies its content
+type cachedFile struct {
+	ModTime int64      `json:"modTime"`
+	Size    int64      `json:"size"`
+	Hash    string     `json:"hash"`
+	Entries []TagEntry `json:"entries"`
+}
+
+// defaultIndexCacheDir returns the directory for index caches below the user cache directory.
+func defaultIndexCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ctags-lsp"), nil
+}
+
+// indexCachePath returns the cache file of the workspace
+func (w *Workspace) indexCachePath() string {
+	sum := sha256.Sum256([]byte(registryKey(w.rootPath, w.settings)))
+	return filepath.Join(w.cacheDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// ctagsVersion returns the first line of 'ctags --version', so a ctags upgrade invalidates caches
+func ctagsVersion(ctagsBin string) string {
+	out, err := exec.Command(ctagsBin, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return line
+}
+
+// newIndexCache creates an empty cache for the workspace
+func (w *Workspace) newIndexCache() *indexCache {
+	return &indexCache{
+		Version:      indexCacheVersion,
+		Root:         w.rootPath,
+		Settings:     w.settings.key(),
+		CtagsVersion: ctagsVersion(w.settings.CtagsBin),
+		Files:        make(map[string]cachedFile),
+	}
+}
+
+// loadIndexCache reads the workspace cache. A missing or outdated cache is returned empty.
+func (w *Workspace) loadIndexCache() *indexCache {
+	current := w.newIndexCache()
+
+	data, err := os.ReadFile(w.indexCachePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logWarnf("Failed to read index cache: %v", err)
+		}
+		return current
+	}
+
+	var cache indexCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		logWarnf("Ignoring corrupt index cache %s: %v", w.indexCachePath(), err)
+		return current
+	}
+	if cache.Version != current.Version || cache.Root != current.Root ||
+		cache.Settings != current.Settings || cache.CtagsVersion != current.CtagsVersion {
+		logInfof("Index cache for %s is outdated", w.rootPath)
+		return current
+	}
+	if cache.Files == nil {
+		cache.Files = make(map[string]cachedFile)
+	}
+	return &cache
+}
+
+// save writes the cache atomically, so concurrent servers never read a partial file.
+func (c *indexCache) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hashFile returns the hex encoded SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileState identifies the content of a file at the time it was checked
+type fileState struct {
+	modTime int64
+	size    int64
+	hash    string
+}
+
+// reuse checks every file against the cache. It returns the cached entries of unchanged
+// files, the files that must be scanned again, and the state of every file so the cache
+// can be updated once the scan has finished.
+func (c *indexCache) reuse(rootPath string, files []string) ([]TagEntry, []string, map[string]fileState) {
+	var reused []TagEntry
+	var stale []string
+	states := make(map[string]fileState, len(files))
+	defer func() {
+		// Deleted files need to be dropped from the cache as well
+		c.dirty = c.dirty || len(stale) > 0 || len(states) != len(c.Files)
+	}()
+
+	for _, file := range files {
+		path := filepath.Join(rootPath, filepath.FromSlash(file))
+		info, err := os.Stat(path)
+		if err != nil {
+			stale = append(stale, file)
+			continue
+		}
+		state := fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+
+		cached, ok := c.Files[file]
+		if ok && cached.Size == state.size && cached.ModTime == state.modTime {
+			state.hash = cached.Hash
+			states[file] = state
+			reused = append(reused, cached.Entries...)
+			continue
+		}
+
+		// Hash before scanning, so a change during the scan is noticed next time
+		c.dirty = true
+		state.hash, err = hashFile(path)
+		if err != nil {
+			stale = append(stale, file)
+			continue
+		}
+		states[file] = state
+
+		if ok && cached.Size == state.size && cached.Hash == state.hash {
+			reused = append(reused, cached.Entries...)
+			continue
+		}
+		stale = append(stale, file)
+	}
+	return reused, stale, states
+}
+
+// saveIndexCache records the entries of every checked file in the cache and writes it,
+// unless nothing changed since it was loaded.
+func (w *Workspace) saveIndexCache(cache *indexCache, states map[string]fileState) {
+	if !cache.dirty {
+		return
+	}
+
+	files := make(map[string]cachedFile, len(states))
+
+	w.mu.RLock()
+	for file, state := range states {
+		var entries []TagEntry
+		w.symbols.inFile(file, func(entry TagEntry) bool {
+			entries = append(entries, entry)
+			return true
+		})
+		files[file] = cachedFile{
+			ModTime: state.modTime,
+			Size:    state.size,
+			Hash:    state.hash,
+			Entries: entries,
+		}
+	}
+	w.mu.RUnlock()
+
+	cache.Files = files
+	if err := cache.save(w.indexCachePath()); err != nil {
+		logWarnf("Failed to write index cache: %v", err)
+		return
+	}
+	logDebugf("Wrote index cache %s", w.indexCachePath())
+}
@@ -0,0 +1,150 @@
+// indexing reports the progress of background workspace scans to the client through
+// 'window/workDoneProgress/create' and '$/progress', and announces when a scan is done.
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// progressInterval is how often indexing progress is reported
+	progressInterval = 250 * time.Millisecond
+	// progressCreateTimeout bounds how long to wait for the client to accept a progress token
+	progressCreateTimeout = 5 * time.Second
+)
+
+// Message types for 'window/showMessage' and 'window/logMessage'
+const (
+	messageTypeError = 1
+	messageTypeInfo  = 3
+)
+
+// indexProgress counts the files of a scan, updated by the scan workers
+type indexProgress struct {
+	done  atomic.Int64
+	total atomic.Int64
+}
+
+// WorkDoneProgressCreateParams represents the parameters of 'window/workDoneProgress/create'
+type WorkDoneProgressCreateParams struct {
+	Token string `json:"token"`
+}
+
+// ProgressParams represents the parameters of the '$/progress' notification
+type ProgressParams struct {
+	Token string `json:"token"`
+	Value any    `json:"value"`
+}
+
+// WorkDoneProgressBegin starts a progress report
+type WorkDoneProgressBegin struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Cancellable bool   `json:"cancellable"`
+	Message     string `json:"message,omitempty"`
+	Percentage  int    `json:"percentage"`
+}
+
+// WorkDoneProgressReport updates a progress report
+type WorkDoneProgressReport struct {
+	Kind       string `json:"kind"`
+	Message    string `json:"message,omitempty"`
+	Percentage int    `json:"percentage"`
+}
+
+// WorkDoneProgressEnd finishes a progress report
+type WorkDoneProgressEnd struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message,omitempty"`
+}
+
+// ShowMessageParams represents the parameters of 'window/showMessage' and 'window/logMessage'
+type ShowMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// status describes the scan progress, returning a message and a percentage
+func (p *indexProgress) status() (string, int) {
+	done, total := p.done.Load(), p.total.Load()
+	if total == 0 {
+		return "Listing files", 0
+	}
+	return fmt.Sprintf("%d/%d files", done, total), int(done * 100 / total)
+}
+
+// reportIndexing follows the scan of a workspace folder until it has finished, reporting
+// progress if the client supports it, and tells the client about the result.
+func (s *Server) reportIndexing(workspace *Workspace) {
+	select {
+	case <-workspace.indexed:
+		// Another connection indexed this folder already
+		return
+	default:
+	}
+
+	token := ""
+	if s.capabilities.Window != nil && s.capabilities.Window.WorkDoneProgress {
+		token = fmt.Sprintf("ctags-lsp/indexing/%d", s.progressSeq.Add(1))
+		ctx, cancel := context.WithTimeout(context.Background(), progressCreateTimeout)
+		_, err := s.out.sendRequest(ctx, "window/workDoneProgress/create", WorkDoneProgressCreateParams{Token: token})
+		cancel()
+		if err != nil {
+			logWarnf("Client refused progress token: %v", err)
+			token = ""
+		}
+	}
+
+	if token != "" {
+		message, percentage := workspace.progress.status()
+		s.out.sendNotification("$/progress", ProgressParams{
+			Token: token,
+			Value: WorkDoneProgressBegin{Kind: "begin", Title: "Indexing " + workspace.rootPath, Message: message, Percentage: percentage},
+		})
+
+		ticker := time.NewTicker(progressInterval)
+	report:
+		for {
+			select {
+			case <-workspace.indexed:
+				break report
+			case <-ticker.C:
+				message, percentage := workspace.progress.status()
+				s.out.sendNotification("$/progress", ProgressParams{
+					Token: token,
+					Value: WorkDoneProgressReport{Kind: "report", Message: message, Percentage: percentage},
+				})
+			}
+		}
+		ticker.Stop()
+	} else {
+		<-workspace.indexed
+	}
+
+	var result string
+	if workspace.indexErr != nil {
+		result = fmt.Sprintf("Indexing %s failed: %v", workspace.rootPath, workspace.indexErr)
+		logErrorf("%s", result)
+		s.out.sendNotification("window/showMessage", ShowMessageParams{Type: messageTypeError, Message: result})
+	} else {
+		result = fmt.Sprintf("Indexed %s (%d files)", workspace.rootPath, workspace.progress.done.Load())
+		s.out.sendNotification("window/logMessage", ShowMessageParams{Type: messageTypeInfo, Message: result})
+	}
+
+	if token != "" {
+		s.out.sendNotification("$/progress", ProgressParams{
+			Token: token,
+			Value: WorkDoneProgressEnd{Kind: "end", Message: result},
+		})
+	}
+}
+
+// waitForIndexing blocks until every workspace folder of the connection has been indexed.
+func (s *Server) waitForIndexing() {
+	for _, workspace := range s.workspaceFolders() {
+		<-workspace.indexed
+	}
+}
@@ -0,0 +1,314 @@
+// jsonrpc contains the JSON-RPC transport: a reader that parses and resynchronizes
+// framed messages, and a single writer that owns the output stream and serializes
+// responses, notifications and server-to-client requests.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxContentLength limits the size of a single message body
+const maxContentLength = 256 << 20
+
+// FrameError reports a message whose headers could not be parsed.
+// The reader skips ahead to the next header block on the following read.
+type FrameError struct {
+	Reason string
+}
+
+func (e *FrameError) Error() string {
+	return "invalid frame: " + e.Reason
+}
+
+// ParseError reports a well-framed message whose body is not valid JSON-RPC
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid JSON-RPC message: %v", e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// MessageReader reads framed JSON-RPC messages from a stream
+type MessageReader struct {
+	r        *bufio.Reader
+	resync   bool      // skip input until the next Content-Length header
+	recorder *Recorder // optional, receives every message body
+}
+
+// NewMessageReader creates a reader for framed JSON-RPC messages.
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next message. It returns io.EOF once the stream is closed,
+// a *FrameError for broken headers and a *ParseError for bodies that are not valid JSON.
+func (mr *MessageReader) Read() (RPCRequest, error) {
+	contentLength := -1
+	sawHeader := false
+
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return RPCRequest{}, err
+		}
+
+		if mr.resync {
+			// The next header may directly follow an unterminated body
+			idx := strings.Index(strings.ToLower(line), "content-length:")
+			if idx < 0 {
+				continue
+			}
+			line = line[idx:]
+			mr.resync = false
+		}
+
+		if line == "" {
+			if !sawHeader {
+				// Tolerate stray blank lines between messages
+				continue
+			}
+			break // End of headers
+		}
+		sawHeader = true
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return RPCRequest{}, mr.badFrame(fmt.Sprintf("malformed header %q", line))
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			cl, err := strconv.Atoi(value)
+			if err != nil || cl < 0 || cl > maxContentLength {
+				return RPCRequest{}, mr.badFrame(fmt.Sprintf("invalid Content-Length %q", value))
+			}
+			contentLength = cl
+		case "content-type":
+			// The only defined charset is utf-8, anything else is read as utf-8 anyway
+			if charset := contentTypeCharset(value); charset != "" && charset != "utf-8" && charset != "utf8" {
+				logWarnf("Unsupported charset %q, assuming utf-8", charset)
+			}
+		default:
+			// Unknown headers are ignored
+		}
+	}
+
+	if contentLength < 0 {
+		return RPCRequest{}, mr.badFrame("missing Content-Length header")
+	}
+
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(mr.r, body); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return RPCRequest{}, io.EOF
+		}
+		return RPCRequest{}, err
+	}
+
+	if mr.recorder != nil {
+		mr.recorder.record(directionIn, body)
+	}
+
+	var req RPCRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return RPCRequest{}, &ParseError{Err: err}
+	}
+	if req.Method == "" && len(req.ID) == 0 {
+		return RPCRequest{}, &ParseError{Err: errors.New("message has neither method nor id")}
+	}
+
+	return req, nil
+}
+
+// readLine reads a single header line without its line ending.
+// A stream that ends in the middle of a line is treated as closed.
+func (mr *MessageReader) readLine() (string, error) {
+	line, err := mr.r.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", io.EOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// badFrame makes the next read skip ahead to the next header block
+func (mr *MessageReader) badFrame(reason string) error {
+	mr.resync = true
+	return &FrameError{Reason: reason}
+}
+
+// contentTypeCharset extracts the lower-cased charset parameter of a Content-Type header
+func contentTypeCharset(value string) string {
+	for _, param := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(key, "charset") {
+			return strings.ToLower(strings.Trim(val, `"`))
+		}
+	}
+	return ""
+}
+
+// RPCNotification represents an outgoing JSON-RPC notification
+type RPCNotification struct {
+	Jsonrpc string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// RPCClientRequest represents a server-to-client JSON-RPC request
+type RPCClientRequest struct {
+	Jsonrpc string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// MessageWriter serializes all outbound frames onto a single stream and tracks
+// server-to-client requests that are waiting for a response.
+type MessageWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	recorder *Recorder // optional, receives every message body
+
+	pendingMu sync.Mutex
+	nextID    int
+	pending   map[string]chan RPCRequest
+}
+
+// NewMessageWriter creates a writer that owns w for the lifetime of the connection.
+func NewMessageWriter(w io.Writer) *MessageWriter {
+	return &MessageWriter{
+		w:       w,
+		pending: make(map[string]chan RPCRequest),
+	}
+}
+
+// send marshals a message and writes it as a single framed message
+func (mw *MessageWriter) send(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling message: %v", err)
+	}
+
+	frame := make([]byte, 0, len(body)+32)
+	frame = fmt.Appendf(frame, "Content-Length: %d\r\n\r\n", len(body))
+	frame = append(frame, body...)
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.recorder != nil {
+		mw.recorder.record(directionOut, body)
+	}
+	if _, err := mw.w.Write(frame); err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+	return nil
+}
+
+// sendResult sends a successful JSON-RPC response
+func (mw *MessageWriter) sendResult(id json.RawMessage, result any) {
+	response := RPCSuccessResponse{
+		Jsonrpc: "2.0",
+		ID:      id,
+		Result:  result,
+	}
+	if err := mw.send(response); err != nil {
+		logErrorf("Failed to send result: %v", err)
+	}
+}
+
+// sendError sends an error JSON-RPC response
+func (mw *MessageWriter) sendError(id json.RawMessage, code int, message string, data any) {
+	response := RPCErrorResponse{
+		Jsonrpc: "2.0",
+		ID:      id,
+		Error: &RPCError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+	if err := mw.send(response); err != nil {
+		logErrorf("Failed to send error: %v", err)
+	}
+}
+
+// sendNotification sends a server-to-client notification
+func (mw *MessageWriter) sendNotification(method string, params any) {
+	notification := RPCNotification{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+	}
+	if err := mw.send(notification); err != nil {
+		logErrorf("Failed to send notification %s: %v", method, err)
+	}
+}
+
+// sendRequest sends a server-to-client request and waits for the client's response.
+func (mw *MessageWriter) sendRequest(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	mw.pendingMu.Lock()
+	mw.nextID++
+	id := mw.nextID
+	ch := make(chan RPCRequest, 1)
+	mw.pending[strconv.Itoa(id)] = ch
+	mw.pendingMu.Unlock()
+
+	defer func() {
+		mw.pendingMu.Lock()
+		delete(mw.pending, strconv.Itoa(id))
+		mw.pendingMu.Unlock()
+	}()
+
+	request := RPCClientRequest{
+		Jsonrpc: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	}
+	if err := mw.send(request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s failed: %s (%d)", method, resp.Error.Message, resp.Error.Code)
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deliverResponse hands a client response to the pending request with the same ID.
+// It reports whether a pending request was found.
+func (mw *MessageWriter) deliverResponse(resp RPCRequest) bool {
+	key := strings.TrimSpace(string(resp.ID))
+
+	mw.pendingMu.Lock()
+	ch, ok := mw.pending[key]
+	mw.pendingMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	ch <- resp
+	return true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// frame wraps a body in a Content-Length header
+func frame(body string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// readAll reads messages until EOF and describes each result: the method of a message,
+// or the kind of error.
+func readAll(t *testing.T, input string) []string {
+	t.Helper()
+	reader := NewMessageReader(strings.NewReader(input))
+
+	var results []string
+	for range 20 {
+		req, err := reader.Read()
+		var frameErr *FrameError
+		var parseErr *ParseError
+		switch {
+		case errors.Is(err, io.EOF):
+			return append(results, "EOF")
+		case errors.As(err, &frameErr):
+			results = append(results, "frame error")
+		case errors.As(err, &parseErr):
+			results = append(results, "parse error")
+		case err != nil:
+			t.Fatalf("unexpected error: %v", err)
+		default:
+			results = append(results, req.Method)
+		}
+	}
+	t.Fatal("reader did not reach EOF")
+	return nil
+}
+
+func TestMessageReaderRead(t *testing.T) {
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize"}`
+	shutdown := `{"jsonrpc":"2.0","id":2,"method":"shutdown"}`
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty stream", "", []string{"EOF"}},
+		{"single message", frame(initialize), []string{"initialize", "EOF"}},
+		{"back to back", frame(initialize) + frame(shutdown), []string{"initialize", "shutdown", "EOF"}},
+		{
+			"content type and header case",
+			"content-length: 46\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n" + initialize,
+			[]string{"initialize", "EOF"},
+		},
+		{"bare newlines", "Content-Length: 46\n\n" + initialize, []string{"initialize", "EOF"}},
+		{"blank lines between messages", frame(initialize) + "\r\n\r\n" + frame(shutdown), []string{"initialize", "shutdown", "EOF"}},
+		{"unknown header", "X-Foo: bar\r\n" + frame(initialize), []string{"initialize", "EOF"}},
+		{
+			"invalid content length",
+			"Content-Length: abc\r\n\r\n" + initialize + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{
+			"negative content length",
+			"Content-Length: -1\r\n\r\n" + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{
+			"content length over the limit",
+			fmt.Sprintf("Content-Length: %d\r\n\r\n", maxContentLength+1) + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{
+			"malformed header",
+			"garbage\r\n\r\n" + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{
+			"missing content length",
+			"Content-Type: application/json\r\n\r\n" + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{
+			"header after unterminated garbage",
+			"garbage\r\n\r\n{\"half\": " + frame(shutdown),
+			[]string{"frame error", "shutdown", "EOF"},
+		},
+		{"truncated body", "Content-Length: 100\r\n\r\n" + initialize, []string{"EOF"}},
+		{"truncated header", "Content-Length: 4", []string{"EOF"}},
+		{"invalid JSON", frame("{not json}") + frame(shutdown), []string{"parse error", "shutdown", "EOF"}},
+		{"neither method nor id", frame(`{"jsonrpc":"2.0"}`) + frame(shutdown), []string{"parse error", "shutdown", "EOF"}},
+		{"response", frame(`{"jsonrpc":"2.0","id":1,"result":null}`), []string{"", "EOF"}},
+		{"multi-byte body", frame(`{"jsonrpc":"2.0","method":"ü"}`), []string{"ü", "EOF"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readAll(t, tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentTypeCharset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"application/vscode-jsonrpc; charset=utf-8", "utf-8"},
+		{"application/vscode-jsonrpc; charset=\"UTF-8\"", "utf-8"},
+		{"application/vscode-jsonrpc;Charset=utf8", "utf8"},
+		{"application/vscode-jsonrpc", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := contentTypeCharset(tt.value); got != tt.want {
+			t.Errorf("contentTypeCharset(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -425,3 +425,20 @@ func GetLSPSymbolKind(ctagsKind string) (int, error) {
 	}
 	return 0, fmt.Errorf("no symbol kind for: %v", ctagsKind)
 }
+
+// isTypeKind reports whether a ctags kind defines a type that a typeref can refer to
+func isTypeKind(ctagsKind string) bool {
+	switch ctagsKind {
+	case "alias", "struct", "talias", "trait", "typealias":
+		return true
+	}
+	kind, err := GetLSPSymbolKind(ctagsKind)
+	if err != nil {
+		return false
+	}
+	switch kind {
+	case SymbolKindClass, SymbolKindInterface, SymbolKindStruct, SymbolKindEnum, SymbolKindTypeParameter:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// lifecycle tracks the LSP server lifecycle (initialize, shutdown, exit) and the ctags
+// child processes that have to be terminated before the server goes away.
+package main
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// lifecycleState is the position of a server in the LSP lifecycle
+type lifecycleState int
+
+const (
+	stateUninitialized lifecycleState = iota
+	stateInitializing
+	stateInitialized
+	stateShutdown
+)
+
+// String returns a human readable name for the state
+func (st lifecycleState) String() string {
+	switch st {
+	case stateUninitialized:
+		return "uninitialized"
+	case stateInitializing:
+		return "initializing"
+	case stateInitialized:
+		return "initialized"
+	case stateShutdown:
+		return "shut down"
+	default:
+		return "unknown"
+	}
+}
+
+// lifecycle guards the lifecycle state of a server
+type lifecycle struct {
+	mu    sync.Mutex
+	state lifecycleState
+}
+
+// get returns the current lifecycle state
+func (l *lifecycle) get() lifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// set unconditionally moves to a new lifecycle state
+func (l *lifecycle) set(state lifecycleState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+// transition moves from one state to another and reports whether the current state matched.
+func (l *lifecycle) transition(from, to lifecycleState) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != from {
+		return false
+	}
+	l.state = to
+	return true
+}
+
+// exitCode returns the process exit code for the current state.
+// Exiting without a prior shutdown is an error.
+func (s *Server) exitCode() int {
+	if s.state.get() != stateShutdown {
+		return 1
+	}
+	return 0
+}
+
+// processTracker keeps track of running ctags processes
+type processTracker struct {
+	mu      sync.Mutex
+	running map[*exec.Cmd]struct{}
+}
+
+// add registers a started process
+func (pt *processTracker) add(cmd *exec.Cmd) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.running == nil {
+		pt.running = make(map[*exec.Cmd]struct{})
+	}
+	pt.running[cmd] = struct{}{}
+}
+
+// remove unregisters a process once it has been waited for
+func (pt *processTracker) remove(cmd *exec.Cmd) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	delete(pt.running, cmd)
+}
+
+// killAll terminates every running process
+func (pt *processTracker) killAll() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	for cmd := range pt.running {
+		if cmd.Process == nil {
+			continue
+		}
+		if err := cmd.Process.Kill(); err != nil {
+			logWarnf("Failed to kill ctags process %d: %v", cmd.Process.Pid, err)
+		}
+	}
+}
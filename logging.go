@@ -0,0 +1,160 @@
+// logging contains leveled logging to stderr or --log-file, and the LSP trace support
+// that mirrors request handling to the client through '$/logTrace' notifications.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logLevel controls which log messages are written
+type logLevel int
+
+const (
+	logLevelError logLevel = iota
+	logLevelWarn
+	logLevelInfo
+	logLevelDebug
+)
+
+// logThreshold is the most verbose level that is written, set once at startup
+var logThreshold = logLevelInfo
+
+// String returns the name used for the level on the command line and in log lines
+func (l logLevel) String() string {
+	switch l {
+	case logLevelError:
+		return "error"
+	case logLevelWarn:
+		return "warn"
+	case logLevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// parseLogLevel converts a --log-level value into a logLevel
+func parseLogLevel(value string) (logLevel, error) {
+	for _, level := range []logLevel{logLevelError, logLevelWarn, logLevelInfo, logLevelDebug} {
+		if strings.EqualFold(value, level.String()) {
+			return level, nil
+		}
+	}
+	return logLevelInfo, fmt.Errorf("invalid log level %q, expected error, warn, info or debug", value)
+}
+
+// setupLogging applies --log-level and redirects the log to --log-file if given.
+func setupLogging(config *Config) error {
+	level, err := parseLogLevel(config.logLevel)
+	if err != nil {
+		return err
+	}
+	logThreshold = level
+
+	if config.logFile != "" {
+		file, err := os.OpenFile(config.logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		log.SetOutput(file)
+	}
+	return nil
+}
+
+// logf writes a log message if its level is enabled
+func logf(level logLevel, format string, args ...any) {
+	if level > logThreshold {
+		return
+	}
+	log.Printf("["+level.String()+"] "+format, args...)
+}
+
+// logErrorf logs failures that break a feature
+func logErrorf(format string, args ...any) {
+	logf(logLevelError, format, args...)
+}
+
+// logWarnf logs problems the server can recover from
+func logWarnf(format string, args ...any) {
+	logf(logLevelWarn, format, args...)
+}
+
+// logInfof logs noteworthy events such as connections
+func logInfof(format string, args ...any) {
+	logf(logLevelInfo, format, args...)
+}
+
+// logDebugf logs details such as per-request timings
+func logDebugf(format string, args ...any) {
+	logf(logLevelDebug, format, args...)
+}
+
+// Trace values as defined by the LSP specification
+const (
+	traceOff      = "off"
+	traceMessages = "messages"
+	traceVerbose  = "verbose"
+)
+
+// SetTraceParams represents the parameters of the '$/setTrace' notification
+type SetTraceParams struct {
+	Value string `json:"value"`
+}
+
+// LogTraceParams represents the parameters of the '$/logTrace' notification
+type LogTraceParams struct {
+	Message string `json:"message"`
+	Verbose string `json:"verbose,omitempty"`
+}
+
+// traceSetting holds the trace value requested by the client
+type traceSetting struct {
+	value atomic.Value
+}
+
+// get returns the current trace value, defaulting to off
+func (t *traceSetting) get() string {
+	if value, ok := t.value.Load().(string); ok {
+		return value
+	}
+	return traceOff
+}
+
+// set stores a trace value, ignoring values the specification doesn't define
+func (t *traceSetting) set(value string) bool {
+	switch value {
+	case traceOff, traceMessages, traceVerbose:
+		t.value.Store(value)
+		return true
+	}
+	return false
+}
+
+// traceRequest logs how long handling a message took and mirrors it to the client
+// as a '$/logTrace' notification when tracing is enabled.
+func (s *Server) traceRequest(req RPCRequest, elapsed time.Duration) {
+	var what string
+	if len(req.ID) > 0 {
+		what = fmt.Sprintf("request '%s - (%s)'", req.Method, req.ID)
+	} else {
+		what = fmt.Sprintf("notification '%s'", req.Method)
+	}
+	message := fmt.Sprintf("Handled %s in %s", what, elapsed.Round(time.Microsecond))
+	logDebugf("%s", message)
+
+	trace := s.trace.get()
+	if trace == traceOff {
+		return
+	}
+
+	params := LogTraceParams{Message: message}
+	if trace == traceVerbose && len(req.Params) > 0 {
+		params.Verbose = "Params: " + string(req.Params)
+	}
+	s.out.sendNotification("$/logTrace", params)
+}
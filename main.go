@@ -4,27 +4,33 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
-// RPCRequest represents a JSON-RPC request structure
+// RPCRequest represents an incoming JSON-RPC message. Requests and notifications use
+// Method and Params, responses to server-to-client requests use Result or Error.
 type RPCRequest struct {
 	Jsonrpc string          `json:"jsonrpc"`
 	ID      json.RawMessage `json:"id"`
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
 }
 
 // RPCSuccessResponse represents a successful JSON-RPC response structure
@@ -50,7 +56,29 @@ type RPCError struct {
 
 // InitializeParams represents parameters for the 'initialize' request
 type InitializeParams struct {
-	RootURI string `json:"rootUri"`
+	RootURI               string             `json:"rootUri"`
+	WorkspaceFolders      []WorkspaceFolder  `json:"workspaceFolders,omitempty"`
+	InitializationOptions json.RawMessage    `json:"initializationOptions,omitempty"`
+	Capabilities          ClientCapabilities `json:"capabilities"`
+	Trace                 string             `json:"trace,omitempty"`
+}
+
+// ClientCapabilities defines the client capabilities the server makes use of
+type ClientCapabilities struct {
+	Workspace    *WorkspaceClientCapabilities    `json:"workspace,omitempty"`
+	TextDocument *TextDocumentClientCapabilities `json:"textDocument,omitempty"`
+	Window       *WindowClientCapabilities       `json:"window,omitempty"`
+	General      *GeneralClientCapabilities      `json:"general,omitempty"`
+}
+
+// GeneralClientCapabilities defines the general client capabilities
+type GeneralClientCapabilities struct {
+	PositionEncodings []string `json:"positionEncodings,omitempty"`
+}
+
+// WindowClientCapabilities defines the window specific client capabilities
+type WindowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
 }
 
 // InitializeResult represents the result of the 'initialize' request
@@ -61,11 +89,29 @@ type InitializeResult struct {
 
 // ServerCapabilities defines the capabilities of the language server
 type ServerCapabilities struct {
+	PositionEncoding        string                   `json:"positionEncoding,omitempty"`
 	TextDocumentSync        *TextDocumentSyncOptions `json:"textDocumentSync,omitempty"`
 	CompletionProvider      *CompletionOptions       `json:"completionProvider,omitempty"`
 	DefinitionProvider      bool                     `json:"definitionProvider,omitempty"`
+	TypeDefinitionProvider  bool                     `json:"typeDefinitionProvider,omitempty"`
+	ReferencesProvider      bool                     `json:"referencesProvider,omitempty"`
+	HoverProvider           bool                     `json:"hoverProvider,omitempty"`
+	SignatureHelpProvider   *SignatureHelpOptions    `json:"signatureHelpProvider,omitempty"`
 	WorkspaceSymbolProvider bool                     `json:"workspaceSymbolProvider,omitempty"`
 	DocumentSymbolProvider  bool                     `json:"documentSymbolProvider,omitempty"`
+	TypeHierarchyProvider   bool                     `json:"typeHierarchyProvider,omitempty"`
+	Workspace               *WorkspaceCapabilities   `json:"workspace,omitempty"`
+}
+
+// WorkspaceCapabilities defines the workspace specific server capabilities
+type WorkspaceCapabilities struct {
+	WorkspaceFolders *WorkspaceFoldersServerCapabilities `json:"workspaceFolders,omitempty"`
+}
+
+// WorkspaceFoldersServerCapabilities defines the server's support for multi-root workspaces
+type WorkspaceFoldersServerCapabilities struct {
+	Supported           bool `json:"supported"`
+	ChangeNotifications bool `json:"changeNotifications"`
 }
 
 // ServerInfo defines the server name and version
@@ -84,6 +130,7 @@ type TextDocumentSyncOptions struct {
 // CompletionOptions defines options for the completion provider
 type CompletionOptions struct {
 	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
+	ResolveProvider   bool     `json:"resolveProvider,omitempty"`
 }
 
 // WorkspaceSymbolParams represents the parameters for the 'workspace/symbol' request
@@ -169,10 +216,19 @@ type PositionParams struct {
 
 // CompletionItem represents a completion suggestion
 type CompletionItem struct {
-	Label         string         `json:"label"`
-	Kind          int            `json:"kind,omitempty"`
-	Detail        string         `json:"detail,omitempty"`
-	Documentation *MarkupContent `json:"documentation,omitempty"`
+	Label         string              `json:"label"`
+	Kind          int                 `json:"kind,omitempty"`
+	Detail        string              `json:"detail,omitempty"`
+	Documentation *MarkupContent      `json:"documentation,omitempty"`
+	Data          *CompletionItemData `json:"data,omitempty"`
+}
+
+// CompletionItemData locates the definition of a completion item for 'completionItem/resolve'.
+// The file is a URI, like in other messages, so that recorded sessions can be replayed elsewhere.
+type CompletionItemData struct {
+	URI      string `json:"uri"`
+	Line     int    `json:"line"`
+	Language string `json:"language,omitempty"`
 }
 
 // MarkupContent represents documentation content
@@ -199,19 +255,45 @@ type Range struct {
 	End   Position `json:"end"`
 }
 
-// Server represents the language server
+// Server represents the language server for a single client connection
 type Server struct {
-	tagEntries  []TagEntry
-	rootPath    string
-	cache       FileCache
-	initialized bool
-	ctagsBin    string
-	tagfilePath string
-	languages   string
-	mu          sync.Mutex
+	out        *MessageWriter
+	folders    []*Workspace
+	foldersMu  sync.RWMutex // guards folders and clientSettings
+	workspaces *workspaceRegistry
+	cache      FileCache
+	buffers    bufferOverlays
+	requests   requestTracker
+	state      lifecycle
+	trace      traceSetting
+	exit       func(code int)
+
+	clientSettings Settings // from initializationOptions and 'workspace/didChangeConfiguration'
+	capabilities   ClientCapabilities
+	encoding       string       // position encoding negotiated at initialize
+	progressSeq    atomic.Int64 // numbers progress tokens
+
+	pending     sync.WaitGroup // dispatched messages that have not been handled yet
+	releaseOnce sync.Once
+	closed      chan struct{} // closed once the workspaces have been released
+}
+
+// newServer creates a server that writes to w and shares workspace indexes through workspaces.
+func newServer(w io.Writer, workspaces *workspaceRegistry) *Server {
+	return &Server{
+		out:        NewMessageWriter(w),
+		workspaces: workspaces,
+		cache: FileCache{
+			content: make(map[string][]string),
+		},
+		buffers:  newBufferOverlays(),
+		exit:     os.Exit,
+		encoding: positionEncodingUTF16,
+		closed:   make(chan struct{}),
+	}
 }
 
-// FileCache stores the content of opened files for quick access
+// FileCache stores the content of opened files for quick access, keyed by filesystem path
 type FileCache struct {
 	mu      sync.RWMutex
 	content map[string][]string
@@ -237,6 +319,14 @@ func (fc *FileCache) GetOrLoadFileContent(filePath string) ([]string, error) {
 	return lines, nil
 }
 
+// GetCachedContent retrieves file content from cache without falling back to disk
+func (fc *FileCache) GetCachedContent(filePath string) ([]string, bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	content, ok := fc.content[filePath]
+	return content, ok
+}
+
 // TagEntry represents a single ctags JSON entry
 type TagEntry struct {
 	Type      string `json:"_type"`
@@ -249,6 +339,43 @@ type TagEntry struct {
 	ScopeKind string `json:"scopeKind,omitempty"`
 	TypeRef   string `json:"typeref,omitempty"`
 	Language  string `json:"language,omitempty"`
+
+	End            int       `json:"end,omitempty"`            // last line of the definition
+	Signature      string    `json:"signature,omitempty"`      // parameter list of functions and methods
+	Access         string    `json:"access,omitempty"`         // e.g. public, private
+	Implementation string    `json:"implementation,omitempty"` // e.g. abstract, virtual
+	Inherits       tagString `json:"inherits,omitempty"`       // comma separated base classes
+	Roles          string    `json:"roles,omitempty"`          // comma separated, "def" for definitions
+	Extras         string    `json:"extras,omitempty"`         // comma separated, e.g. fileScope
+}
+
+// tagString is a string field that ctags may write with another type, such as inherits,
+// which is false for classes without base classes. Values that aren't strings decode as
+// empty rather than failing the whole entry.
+type tagString string
+
+// UnmarshalJSON decodes a JSON string and treats any other value as empty
+func (t *tagString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = ""
+	}
+	*t = tagString(s)
+	return nil
+}
+
+// isDefinition reports whether an entry defines its symbol rather than referencing it.
+// Entries without roles come from ctags versions or tagfiles that only contain definitions.
+func (e TagEntry) isDefinition() bool {
+	if e.Roles == "" {
+		return true
+	}
+	for _, role := range strings.Split(e.Roles, ",") {
+		if role == "def" {
+			return true
+		}
+	}
+	return false
 }
 
 // getInstallInstructions returns OS-specific installation instructions for Universal Ctags
@@ -293,22 +420,22 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := setupLogging(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Check for ctags installation before proceeding
 	if err := checkCtagsInstallation(config.ctagsBin); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	workspaces := newWorkspaceRegistry(config)
+
 	if config.benchmark {
 		// Mock the server
-		server := &Server{
-			cache: FileCache{
-				content: make(map[string][]string),
-			},
-			ctagsBin:    config.ctagsBin,
-			tagfilePath: config.tagfilePath,
-			languages:   config.languages,
-		}
+		server := newServer(os.Stdout, workspaces)
 
 		// Mock the JSON-RPC request for 'initialize'
 		mockID := json.RawMessage(`1`)
@@ -324,84 +451,59 @@ func main() {
 
 		// Run ctags on the project
 		handleInitialize(server, mockReq)
+		server.waitForIndexing()
 
 		// Exit immediately after initialize
 		os.Exit(0)
 	}
 
-	server := &Server{
-		cache: FileCache{
-			content: make(map[string][]string),
-		},
-		ctagsBin:    config.ctagsBin,
-		tagfilePath: config.tagfilePath,
-		languages:   config.languages,
+	if config.replay != "" {
+		os.Exit(replaySession(config.replay, config.replayRoot, workspaces))
 	}
 
-	// Main loop to handle LSP messages
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		req, err := readMessage(reader)
-		if err != nil {
-			sendError(nil, -32600, "Malformed request", err.Error())
-			continue // Ignore malformed request
+	if config.listen != "" {
+		if config.record != "" {
+			fmt.Fprintf(os.Stderr, "Error: --record can't be combined with --listen\n")
+			os.Exit(1)
 		}
-
-		// Handle request in a separate goroutine
-		go handleRequest(server, req)
+		if err := listen(config, workspaces); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-}
 
-// readMessage reads a single JSON-RPC message from the reader
-func readMessage(reader *bufio.Reader) (RPCRequest, error) {
-	contentLength := 0
-	for {
-		line, err := reader.ReadString('\r')
+	server := newServer(os.Stdout, workspaces)
+	if config.record != "" {
+		recorder, err := NewRecorder(config.record)
 		if err != nil {
-			return RPCRequest{}, fmt.Errorf("error reading header: %v", err)
-		}
-		b, err := reader.ReadByte()
-		if err != nil {
-			return RPCRequest{}, fmt.Errorf("error reading header: %v", err)
-		}
-		if b != '\n' {
-			return RPCRequest{}, fmt.Errorf("line endings must be \\r\\n")
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-		if line == "\r" {
-			break // End of headers
-		}
-		if after, ok := strings.CutPrefix(line, "Content-Length:"); ok {
-			clStr := strings.TrimSpace(after)
-			cl, err := strconv.Atoi(clStr)
-			if err != nil {
-				return RPCRequest{}, fmt.Errorf("invalid Content-Length: %v", err)
-			}
-			contentLength = cl
-		}
-	}
-
-	body := make([]byte, contentLength)
-	_, err := io.ReadFull(reader, body)
-	if err != nil {
-		return RPCRequest{}, fmt.Errorf("error reading body: %v", err)
+		server.out.recorder = recorder
 	}
+	server.serve(os.Stdin)
 
-	var req RPCRequest
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		return RPCRequest{}, fmt.Errorf("invalid JSON-RPC request: %v", err)
-	}
-
-	return req, nil
+	// Input closed without an exit notification
+	server.releaseWorkspaces()
+	os.Exit(server.exitCode())
 }
 
 // Config holds command-line configuration options
 type Config struct {
-	showVersion bool
-	benchmark   bool
-	ctagsBin    string
-	tagfilePath string
-	languages   string
+	showVersion  bool
+	benchmark    bool
+	noIndexCache bool
+	pollInterval time.Duration
+	ctagsBin     string
+	tagfilePath  string
+	languages    string
+	listen       string
+	logFile      string
+	logLevel     string
+	record       string
+	replay       string
+	replayRoot   string
 }
 
 func parseFlags(args []string) *Config {
@@ -410,9 +512,17 @@ func parseFlags(args []string) *Config {
 	flag.Usage = flagUsage
 	flag.BoolVar(&config.showVersion, "version", false, "")
 	flag.BoolVar(&config.benchmark, "benchmark", false, "")
+	flag.BoolVar(&config.noIndexCache, "no-index-cache", false, "")
+	flag.DurationVar(&config.pollInterval, "poll-interval", 0, "")
 	flag.StringVar(&config.ctagsBin, "ctags-bin", "ctags", "")
 	flag.StringVar(&config.tagfilePath, "tagfile", "", "")
 	flag.StringVar(&config.languages, "languages", "", "")
+	flag.StringVar(&config.listen, "listen", "", "")
+	flag.StringVar(&config.logFile, "log-file", "", "")
+	flag.StringVar(&config.logLevel, "log-level", "info", "")
+	flag.StringVar(&config.record, "record", "", "")
+	flag.StringVar(&config.replay, "replay", "", "")
+	flag.StringVar(&config.replayRoot, "replay-root", ".", "")
 
 	flag.CommandLine.Parse(args[1:])
 
@@ -432,31 +542,67 @@ Options:
   --ctags-bin <name>   Use custom ctags binary name (default: "ctags")
   --tagfile <path>     Use custom tagfile (default: tries "tags", ".tags" and ".git/tags")
   --languages <value>  Pass through language filter list to ctags
+  --no-index-cache     Don't keep an index cache in the user cache directory
+  --poll-interval <d>  Poll for file changes if the client can't watch files (e.g. "5s")
+  --listen <address>   Serve clients on a socket instead of stdio
+                       (tcp:HOST:PORT or unix:PATH)
+  --log-file <path>    Append logs to a file instead of stderr
+  --log-level <level>  Log verbosity: error, warn, info or debug (default: "info")
+  --record <file>      Record every JSON-RPC message of the session to a file
+  --replay <file>      Replay a recorded session and report differing responses
+  --replay-root <dir>  Workspace to replay the session against (default: ".")
 `, os.Args[0])
 }
 
-// checkInitializedOrFail ensures that the server has been successfully initialized.
+// checkInitializedOrFail ensures that the request is allowed in the current lifecycle state.
+// Rejected notifications are dropped silently, rejected requests receive an error.
 func checkInitializedOrFail(id json.RawMessage, server *Server, method string) bool {
-	// The following methods are allowed even if not initialized:
-	// - initialize (the first request)
-	// - shutdown and exit (for cleanup)
-	if method == "initialize" || method == "shutdown" || method == "exit" {
+	isRequest := len(id) > 0
+
+	// exit is always allowed, the exit code depends on the state
+	if method == "exit" {
 		return true
 	}
 
-	if !server.initialized {
-		sendError(id, -32002, "Server not initialized", "Received request before successful initialization")
+	switch state := server.state.get(); state {
+	case stateUninitialized, stateInitializing:
+		if method == "initialize" {
+			// Only one initialize may be processed at a time
+			if server.state.transition(stateUninitialized, stateInitializing) {
+				return true
+			}
+			server.out.sendError(id, -32600, "Invalid request", "Server is already initializing")
+			return false
+		}
+		if isRequest {
+			server.out.sendError(id, -32002, "Server not initialized", "Received request before successful initialization")
+		}
+		return false
+	case stateInitialized:
+		if method == "initialize" {
+			server.out.sendError(id, -32600, "Invalid request", "Server is already initialized")
+			return false
+		}
+		if method == "shutdown" {
+			// Reject everything that arrives after shutdown, even if it runs first
+			server.state.set(stateShutdown)
+		}
+		return true
+	default:
+		if isRequest {
+			server.out.sendError(id, -32600, "Invalid request", fmt.Sprintf("Server is %s", state))
+		}
 		return false
 	}
-	return true
 }
 
-// handleRequest routes JSON-RPC requests to appropriate handlers
-func handleRequest(server *Server, req RPCRequest) {
-	if !checkInitializedOrFail(req.ID, server, req.Method) {
-		// Server not initialized and request is not allowed.
-		return
-	}
+// handleRequest routes JSON-RPC requests to appropriate handlers. ctx is cancelled
+// when the client cancels the request.
+func handleRequest(ctx context.Context, server *Server, req RPCRequest) {
+	start := time.Now()
+	defer func() {
+		server.traceRequest(req, time.Since(start))
+	}()
 
 	switch req.Method {
 	case "initialize":
@@ -475,24 +621,47 @@ func handleRequest(server *Server, req RPCRequest) {
 		handleDidClose(server, req)
 	case "textDocument/didSave":
 		handleDidSave(server, req)
+	case "workspace/didChangeWorkspaceFolders":
+		handleDidChangeWorkspaceFolders(server, req)
+	case "workspace/didChangeConfiguration":
+		handleDidChangeConfiguration(server, req)
+	case "workspace/didChangeWatchedFiles":
+		handleDidChangeWatchedFiles(server, req)
 	case "textDocument/completion":
-		handleCompletion(server, req)
+		handleCompletion(ctx, server, req)
+	case "completionItem/resolve":
+		handleCompletionResolve(server, req)
 	case "textDocument/definition":
-		handleDefinition(server, req)
+		handleDefinition(ctx, server, req)
+	case "textDocument/typeDefinition":
+		handleTypeDefinition(ctx, server, req)
+	case "textDocument/references":
+		handleReferences(ctx, server, req)
+	case "textDocument/hover":
+		handleHover(ctx, server, req)
+	case "textDocument/signatureHelp":
+		handleSignatureHelp(ctx, server, req)
 	case "workspace/symbol":
-		handleWorkspaceSymbol(server, req)
+		handleWorkspaceSymbol(ctx, server, req)
 	case "textDocument/documentSymbol":
-		handleDocumentSymbol(server, req)
+		handleDocumentSymbol(ctx, server, req)
+	case "textDocument/prepareTypeHierarchy":
+		handlePrepareTypeHierarchy(ctx, server, req)
+	case "typeHierarchy/supertypes":
+		handleTypeHierarchySupertypes(ctx, server, req)
+	case "typeHierarchy/subtypes":
+		handleTypeHierarchySubtypes(ctx, server, req)
 	case "$/cancelRequest":
 		handleCancelRequest(server, req)
 	case "$/setTrace":
 		handleSetTrace(server, req)
-	case "$/logTrace":
-		handleLogTrace(server, req)
 	default:
-		// Method not found
+		// Method not found, unknown notifications are ignored
+		if len(req.ID) == 0 {
+			return
+		}
 		message := fmt.Sprintf("Method not found: %s", req.Method)
-		sendError(req.ID, -32601, message, nil)
+		server.out.sendError(req.ID, -32601, message, nil)
 	}
 }
 
@@ -501,36 +670,61 @@ func handleInitialize(server *Server, req RPCRequest) {
 	var params InitializeParams
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
-		sendError(req.ID, -32602, "Invalid params", nil)
+		server.state.set(stateUninitialized)
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
 		return
 	}
 
-	if params.RootURI == "" {
-		// Use current working directory if RootURI is empty
+	// Workspace folders take precedence over the root URI
+	var rootPaths []string
+	for _, folder := range params.WorkspaceFolders {
+		rootPaths = append(rootPaths, uriToPath(folder.URI))
+	}
+	if len(rootPaths) == 0 && params.RootURI != "" {
+		rootPaths = append(rootPaths, uriToPath(params.RootURI))
+	}
+	if len(rootPaths) == 0 {
+		// Use current working directory if no root is given
 		cwd, err := os.Getwd()
 		if err != nil {
-			sendError(req.ID, -32603, "Failed to get current working directory", err.Error())
+			server.state.set(stateUninitialized)
+			server.out.sendError(req.ID, -32603, "Failed to get current working directory", err.Error())
 			return
 		}
-		server.rootPath = cwd
-	} else {
-		// Convert RootURI to filesystem path
-		rootPath := params.RootURI
-		if after, ok := strings.CutPrefix(rootPath, "file://"); ok {
-			rootPath = filepath.FromSlash(after)
+		rootPaths = append(rootPaths, cwd)
+	}
+
+	// Invalid options shouldn't leave the server unusable, so fall back to the defaults
+	settings, _, err := parseSettings(params.InitializationOptions)
+	if err != nil {
+		logWarnf("Ignoring invalid initializationOptions: %v", err)
+		settings = Settings{}
+	}
+	server.foldersMu.Lock()
+	server.clientSettings = settings
+	server.foldersMu.Unlock()
+
+	server.capabilities = params.Capabilities
+	if params.Capabilities.General != nil {
+		server.encoding = negotiatePositionEncoding(params.Capabilities.General.PositionEncodings)
+	}
+
+	// Index in the background, or reuse the index of another connection to the same folder
+	var indexing []*Workspace
+	for _, rootPath := range rootPaths {
+		if workspace := server.addWorkspaceFolder(rootPath); workspace != nil {
+			indexing = append(indexing, workspace)
 		}
-		server.rootPath = rootPath
 	}
 
-	// Load ctags entries
-	if err := server.scanWorkspace(); err != nil {
-		sendError(req.ID, -32603, "Internal error while scanning tags", err.Error())
-		return
+	if params.Trace != "" && !server.trace.set(params.Trace) {
+		logWarnf("Ignoring unknown trace value %q", params.Trace)
 	}
 
 	// Define server capabilities
 	result := InitializeResult{
 		Capabilities: ServerCapabilities{
+			PositionEncoding: server.encoding,
 			TextDocumentSync: &TextDocumentSyncOptions{
 				Change:    1, // Full synchronization
 				OpenClose: true,
@@ -538,10 +732,24 @@ func handleInitialize(server *Server, req RPCRequest) {
 			},
 			CompletionProvider: &CompletionOptions{
 				TriggerCharacters: []string{".", "\""},
+				ResolveProvider:   true,
 			},
 			WorkspaceSymbolProvider: true,
 			DefinitionProvider:      true,
-			DocumentSymbolProvider:  true,
+			TypeDefinitionProvider:  true,
+			ReferencesProvider:      true,
+			HoverProvider:           true,
+			SignatureHelpProvider: &SignatureHelpOptions{
+				TriggerCharacters: []string{"(", ","},
+			},
+			DocumentSymbolProvider: true,
+			TypeHierarchyProvider:  true,
+			Workspace: &WorkspaceCapabilities{
+				WorkspaceFolders: &WorkspaceFoldersServerCapabilities{
+					Supported:           true,
+					ChangeNotifications: true,
+				},
+			},
 		},
 		Info: ServerInfo{
 			Name:    "ctags-lsp",
@@ -549,42 +757,59 @@ func handleInitialize(server *Server, req RPCRequest) {
 		},
 	}
 
-	sendResult(req.ID, result)
-	server.initialized = true
+	// Mark as initialized before replying so the client's next request is accepted
+	server.state.set(stateInitialized)
+	server.out.sendResult(req.ID, result)
+
+	// Progress may only be reported once the client has received the result
+	for _, workspace := range indexing {
+		go server.reportIndexing(workspace)
+	}
 }
 
 // handleInitialized processes the 'initialized' notification
-func handleInitialized(_ *Server, _ RPCRequest) {
-	// 'initialized' is a notification with no response
+func handleInitialized(server *Server, _ RPCRequest) {
+	// 'initialized' is a notification with no response, but the client now accepts registrations
+	server.startWatching()
 }
 
 // handleShutdown processes the 'shutdown' request
-func handleShutdown(_ *Server, req RPCRequest) {
-	sendResult(req.ID, nil)
+func handleShutdown(server *Server, req RPCRequest) {
+	// Requests received before shutdown are still answered and may read the workspace
+	server.pending.Wait()
+	server.releaseWorkspaces()
+	server.out.sendResult(req.ID, nil)
 }
 
 // handleExit processes the 'exit' notification
-func handleExit(_ *Server, _ RPCRequest) {
-	os.Exit(0)
+func handleExit(server *Server, _ RPCRequest) {
+	server.releaseWorkspaces()
+	server.exit(server.exitCode())
 }
 
 // handleCancelRequest processes the '$/cancelRequest' notification
 // (For canceling in-progress requests)
-func handleCancelRequest(_ *Server, _ RPCRequest) {
-	// Not currently in use
+func handleCancelRequest(server *Server, req RPCRequest) {
+	var params CancelParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ID) == 0 {
+		return
+	}
+
+	// The cancelled handler answers with RequestCancelled once it notices
+	server.requests.cancel(params.ID)
 }
 
 // handleSetTrace() processes the '$/setTrace' notification
-// (Controls trace output level)
-func handleSetTrace(_ *Server, req RPCRequest) {
-	// Not currently in use
-	sendResult(req.ID, nil)
-}
+// (Controls trace output level, traces are sent as '$/logTrace' notifications)
+func handleSetTrace(server *Server, req RPCRequest) {
+	var params SetTraceParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return
+	}
 
-// handleLogTrace() processes the '$/logTrace' notification
-// (For transmitting trace data)
-func handleLogTrace(_ *Server, _ RPCRequest) {
-	// Not currently in use
+	if !server.trace.set(params.Value) {
+		logWarnf("Ignoring unknown trace value %q", params.Value)
+	}
 }
 
 // handleDidOpen processes the 'textDocument/didOpen' notification
@@ -595,11 +820,8 @@ func handleDidOpen(server *Server, req RPCRequest) {
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
-	if err != nil {
-		log.Printf("Failed to normalize path for didOpen: %v", err)
-		return
-	}
+	// Documents outside the workspace folders are cached too
+	filePath := uriToPath(params.TextDocument.URI)
 
 	content := strings.Split(params.TextDocument.Text, "\n")
 
@@ -607,6 +829,8 @@ func handleDidOpen(server *Server, req RPCRequest) {
 	server.cache.mu.Lock()
 	server.cache.content[filePath] = content
 	server.cache.mu.Unlock()
+
+	server.openBuffer(filePath, params.TextDocument.LanguageID, params.TextDocument.Text)
 }
 
 // handleDidChange processes the 'textDocument/didChange' notification
@@ -617,11 +841,8 @@ func handleDidChange(server *Server, req RPCRequest) {
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
-	if err != nil {
-		log.Printf("Failed to normalize path for didChange: %v", err)
-		return
-	}
+	// Documents outside the workspace folders are cached too
+	filePath := uriToPath(params.TextDocument.URI)
 
 	if len(params.ContentChanges) > 0 {
 		content := strings.Split(params.ContentChanges[0].Text, "\n")
@@ -629,6 +850,8 @@ func handleDidChange(server *Server, req RPCRequest) {
 		server.cache.mu.Lock()
 		server.cache.content[filePath] = content
 		server.cache.mu.Unlock()
+
+		server.scheduleRetag(filePath)
 	}
 }
 
@@ -640,16 +863,15 @@ func handleDidClose(server *Server, req RPCRequest) {
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
-	if err != nil {
-		log.Printf("Failed to normalize path for didClose: %v", err)
-		return
-	}
+	// Documents outside the workspace folders are cached too
+	filePath := uriToPath(params.TextDocument.URI)
 
 	// Remove the document from cache
 	server.cache.mu.Lock()
 	delete(server.cache.content, filePath)
 	server.cache.mu.Unlock()
+
+	server.closeBuffer(filePath)
 }
 
 // handleDidSave processes the 'textDocument/didSave' notification
@@ -660,56 +882,86 @@ func handleDidSave(server *Server, req RPCRequest) {
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
+	workspace, filePath, err := server.owningWorkspace(params.TextDocument.URI)
 	if err != nil {
-		log.Printf("Failed to normalize path for didSave: %v", err)
+		logWarnf("Failed to normalize path for didSave: %v", err)
+		return
+	}
+
+	if isProjectConfigFile(filePath) {
+		server.reindexChangedFolders()
 		return
 	}
 
 	// Scan the file again
-	if err := server.scanSingleFileTag(filePath); err != nil {
-		log.Printf("Error rescanning file %s: %v", filePath, err)
+	if err := workspace.scanSingleFileTag(filePath); err != nil {
+		logErrorf("Error rescanning file %s: %v", filePath, err)
 	}
 }
 
-// handleCompletion processes the 'textDocument/completion' request
-func handleCompletion(server *Server, req RPCRequest) {
-	var params CompletionParams
-	err := json.Unmarshal(req.Params, &params)
+// handleDidChangeWorkspaceFolders processes the 'workspace/didChangeWorkspaceFolders' notification
+func handleDidChangeWorkspaceFolders(server *Server, req RPCRequest) {
+	var params DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return
+	}
+
+	for _, folder := range params.Event.Removed {
+		server.removeWorkspaceFolder(uriToPath(folder.URI))
+	}
+	for _, folder := range params.Event.Added {
+		if workspace := server.addWorkspaceFolder(uriToPath(folder.URI)); workspace != nil {
+			go server.reportIndexing(workspace)
+		}
+	}
+}
+
+// handleDidChangeConfiguration processes the 'workspace/didChangeConfiguration' notification
+func handleDidChangeConfiguration(server *Server, req RPCRequest) {
+	var params DidChangeConfigurationParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return
+	}
+
+	settings, ok, err := parseSettings(params.Settings)
 	if err != nil {
-		sendError(req.ID, -32602, "Invalid params", nil)
+		logWarnf("Ignoring invalid settings: %v", err)
 		return
 	}
+	if !ok {
+		return // Keep the current settings
+	}
+	server.applySettings(settings)
+}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
+// handleCompletion processes the 'textDocument/completion' request
+func handleCompletion(ctx context.Context, server *Server, req RPCRequest) {
+	var params CompletionParams
+	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
-		sendError(req.ID, -32603, "Internal error", err.Error())
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
 		return
 	}
+
+	filePath := uriToPath(params.TextDocument.URI)
 	currentFileExt := filepath.Ext(filePath)
 
 	// Get the line content and check if the character before the cursor is a dot
-	server.cache.mu.RLock()
-	lines, ok := server.cache.content[filePath]
-	server.cache.mu.RUnlock()
+	lines, ok := server.openDocumentLines(ctx, filePath)
 
 	if !ok || params.Position.Line >= len(lines) {
-		sendError(req.ID, -32603, "Internal error", "Line out of range")
+		server.out.sendError(req.ID, -32603, "Internal error", "Line out of range")
 		return
 	}
 
 	lineContent := lines[params.Position.Line]
-	runes := []rune(lineContent)
-	isAfterDot := false
-	if params.Position.Character > 0 && params.Position.Character-1 < len(runes) {
-		prevChar := runes[params.Position.Character-1]
-		isAfterDot = prevChar == '.'
-	}
+	offset, _ := characterToByteOffset(lineContent, params.Position.Character, server.encoding)
+	isAfterDot := strings.HasSuffix(lineContent[:offset], ".")
 
 	// Retrieve the current word at the cursor position
-	word, err := server.getCurrentWord(filePath, params.Position)
+	word, err := server.getCurrentWord(ctx, filePath, params.Position)
 	if err != nil {
-		sendResult(req.ID, CompletionList{
+		server.out.sendResult(req.ID, CompletionList{
 			IsIncomplete: false,
 			Items:        []CompletionItem{},
 		})
@@ -719,50 +971,62 @@ func handleCompletion(server *Server, req RPCRequest) {
 	var items []CompletionItem
 	seenItems := make(map[string]bool)
 
-	for _, entry := range server.tagEntries {
-		if strings.HasPrefix(strings.ToLower(entry.Name), strings.ToLower(word)) {
-			if seenItems[entry.Name] {
-				continue // Avoid duplicate entries
-			}
+	err = server.forEachWithPrefix(ctx, word, func(workspace *Workspace, entry TagEntry) {
+		if seenItems[entry.Name] {
+			return // Avoid duplicate entries
+		}
 
-			kind := GetLSPCompletionKind(entry.Kind)
+		kind := GetLSPCompletionKind(entry.Kind)
 
-			// Get the file extension of the entry's file
-			entryFilePath := filepath.Join(server.rootPath, entry.Path)
-			entryFileExt := filepath.Ext(entryFilePath)
+		// Get the file extension of the entry's file
+		entryFileExt := filepath.Ext(entry.Path)
 
-			// Decide whether to include this entry
-			includeEntry := false
+		// Decide whether to include this entry
+		includeEntry := false
 
-			if isAfterDot {
-				// After a dot, only include methods and functions, excluding 'text' items
-				if (kind == CompletionItemKindMethod || kind == CompletionItemKindFunction) && entryFileExt == currentFileExt {
-					includeEntry = true
-				}
-			} else {
-				// Not after a dot
-				if kind == CompletionItemKindText {
-					// Always include 'text' items
-					includeEntry = true
-				} else if entryFileExt == currentFileExt {
-					// Include items from files with the same extension
-					includeEntry = true
-				}
+		if isAfterDot {
+			// After a dot, only include methods and functions, excluding 'text' items
+			if (kind == CompletionItemKindMethod || kind == CompletionItemKindFunction) && entryFileExt == currentFileExt {
+				includeEntry = true
+			}
+		} else {
+			// Not after a dot
+			if kind == CompletionItemKindText {
+				// Always include 'text' items
+				includeEntry = true
+			} else if entryFileExt == currentFileExt {
+				// Include items from files with the same extension
+				includeEntry = true
 			}
+		}
+
+		if includeEntry {
+			seenItems[entry.Name] = true
 
-			if includeEntry {
-				seenItems[entry.Name] = true
-				items = append(items, CompletionItem{
-					Label:  entry.Name,
-					Kind:   kind,
-					Detail: fmt.Sprintf("%s:%d (%s)", entry.Path, entry.Line, entry.Kind),
-					Documentation: &MarkupContent{
-						Kind:  "plaintext",
-						Value: entry.Pattern,
-					},
-				})
+			// The doc comment is read from the file when the item is resolved
+			declaration, ok := patternLine(entry.Pattern)
+			if !ok {
+				declaration = entry.Pattern
 			}
+			var data *CompletionItemData
+			if uri, err := relativePathToAbsoluteURI(workspace.rootPath, entry.Path); err == nil {
+				data = &CompletionItemData{URI: uri, Line: entry.Line, Language: entry.Language}
+			}
+			items = append(items, CompletionItem{
+				Label:  entry.Name,
+				Kind:   kind,
+				Detail: fmt.Sprintf("%s:%d (%s)", entry.Path, entry.Line, entry.Kind),
+				Documentation: &MarkupContent{
+					Kind:  "plaintext",
+					Value: strings.TrimSpace(declaration),
+				},
+				Data: data,
+			})
 		}
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
 	}
 
 	result := CompletionList{
@@ -770,175 +1034,184 @@ func handleCompletion(server *Server, req RPCRequest) {
 		Items:        items,
 	}
 
-	sendResult(req.ID, result)
+	server.out.sendResult(req.ID, result)
+}
+
+// handleCompletionResolve processes the 'completionItem/resolve' request, adding the doc
+// comment of the definition to the item's documentation
+func handleCompletionResolve(server *Server, req RPCRequest) {
+	var item CompletionItem
+	if err := json.Unmarshal(req.Params, &item); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	if item.Data != nil {
+		lines, err := server.documentLines(uriToPath(item.Data.URI))
+		if err == nil {
+			if doc := docComment(lines, item.Data.Line, item.Data.Language); doc != "" {
+				value := doc
+				if item.Documentation != nil && item.Documentation.Value != "" {
+					value = fmt.Sprintf("```%s\n%s\n```\n\n%s", fenceLanguage(item.Data.Language), item.Documentation.Value, doc)
+				}
+				item.Documentation = &MarkupContent{Kind: "markdown", Value: value}
+			}
+		}
+	}
+
+	server.out.sendResult(req.ID, item)
 }
 
 // handleDefinition processes the 'textDocument/definition' request
-func handleDefinition(server *Server, req RPCRequest) {
+func handleDefinition(ctx context.Context, server *Server, req RPCRequest) {
 	var params TextDocumentPositionParams
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
-		sendError(req.ID, -32602, "Invalid params", nil)
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
+	filePath := uriToPath(params.TextDocument.URI)
+
+	// Get the current word at the given position
+	symbol, err := server.getCurrentWord(ctx, filePath, params.Position)
 	if err != nil {
-		sendError(req.ID, -32603, "Internal error", err.Error())
+		server.out.sendResult(req.ID, nil) // No symbol found at position or error occurred
 		return
 	}
 
-	// Get the current word at the given position
-	symbol, err := server.getCurrentWord(filePath, params.Position)
+	// Search for the symbol in the tag entries of every workspace folder
+	var matches []workspaceEntry
+	err = server.forEachNamed(ctx, symbol, func(workspace *Workspace, entry TagEntry) {
+		matches = append(matches, workspaceEntry{workspace, entry})
+	})
 	if err != nil {
-		sendResult(req.ID, nil) // No symbol found at position or error occurred
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
 		return
 	}
 
-	// Search for the symbol in the tagEntries
-	server.mu.Lock()
-	defer server.mu.Unlock()
-
+	// Create a Location for each of the symbol's definitions
 	var locations []Location
-	for _, entry := range server.tagEntries {
-		if entry.Name == symbol {
-			// Create a Location for the symbol's definition
-			uri, err := relativePathToAbsoluteURI(server.rootPath, entry.Path)
-			if err != nil {
-				log.Printf("Failed to build URI for %s: %v", entry.Path, err)
-				continue
-			}
-
-			// Use the refactored method to get file content
-			content, err := server.cache.GetOrLoadFileContent(entry.Path)
-			if err != nil {
-				log.Printf("Failed to get content for file %s: %v", entry.Path, err)
-				continue
-			}
-
-			// Find the symbol's range within the file
-			symbolRange := findSymbolRangeInFile(content, entry.Name, entry.Line)
-
-			location := Location{
-				URI:   uri,
-				Range: symbolRange,
-			}
+	for _, m := range matches {
+		if location, ok := server.tagLocation(m.workspace, m.entry); ok {
 			locations = append(locations, location)
 		}
 	}
 
 	// Send the locations back
 	if len(locations) == 0 {
-		sendResult(req.ID, nil) // No definition found
+		server.out.sendResult(req.ID, nil) // No definition found
 	} else if len(locations) == 1 {
-		sendResult(req.ID, locations[0])
+		server.out.sendResult(req.ID, locations[0])
 	} else {
-		sendResult(req.ID, locations)
+		server.out.sendResult(req.ID, locations)
 	}
 }
 
 // handleWorkspaceSymbol processes the 'workspace/symbol' request
-func handleWorkspaceSymbol(server *Server, req RPCRequest) {
+func handleWorkspaceSymbol(ctx context.Context, server *Server, req RPCRequest) {
 	var params WorkspaceSymbolParams
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
-		sendError(req.ID, -32602, "Invalid params", nil)
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
 		return
 	}
 
-	query := params.Query
-	var symbols []SymbolInformation
-
-	server.mu.Lock()
-	defer server.mu.Unlock()
-
-	for _, entry := range server.tagEntries {
-		if query != "" && entry.Name != query {
-			continue
+	var matches []workspaceEntry
+	addMatch := func(workspace *Workspace, entry TagEntry) {
+		if _, err := GetLSPSymbolKind(entry.Kind); err == nil {
+			matches = append(matches, workspaceEntry{workspace, entry})
 		}
+		// Tags without a symbol kind are skipped
+	}
 
-		kind, err := GetLSPSymbolKind(entry.Kind)
-		if err != nil {
-			// This tag has no symbol kind, skip
-			continue
-		}
-		uri, err := relativePathToAbsoluteURI(server.rootPath, entry.Path)
-		if err != nil {
-			log.Printf("Failed to build URI for %s: %v", entry.Path, err)
-			continue
-		}
+	// An empty query lists every symbol, otherwise the name must match exactly
+	if params.Query == "" {
+		err = server.forEachEntry(ctx, addMatch)
+	} else {
+		err = server.forEachNamed(ctx, params.Query, addMatch)
+	}
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
 
-		// Use the refactored method to get file content
-		content, err := server.cache.GetOrLoadFileContent(entry.Path)
-		if err != nil {
-			log.Printf("Failed to get content for file %s: %v", entry.Path, err)
-			continue
+	// Locating the symbols reads their files, which can take a while for an empty query
+	var symbols []SymbolInformation
+	for _, m := range matches {
+		if isCancelled(ctx) {
+			server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+			return
 		}
-
-		// Find the symbol's range within the file
-		symbolRange := findSymbolRangeInFile(content, entry.Name, entry.Line)
-
-		symbol := SymbolInformation{
-			Name: entry.Name,
-			Kind: kind,
-			Location: Location{
-				URI:   uri,
-				Range: symbolRange,
-			},
-			ContainerName: entry.Scope,
+		location, ok := server.tagLocation(m.workspace, m.entry)
+		if !ok {
+			continue
 		}
-		symbols = append(symbols, symbol)
+		kind, _ := GetLSPSymbolKind(m.entry.Kind)
+		symbols = append(symbols, SymbolInformation{
+			Name:          m.entry.Name,
+			Kind:          kind,
+			Location:      location,
+			ContainerName: m.entry.Scope,
+		})
 	}
 
-	sendResult(req.ID, symbols)
+	server.out.sendResult(req.ID, symbols)
 }
 
 // handleDocumentSymbol processes the 'textDocument/documentSymbol' request
-func handleDocumentSymbol(server *Server, req RPCRequest) {
+func handleDocumentSymbol(ctx context.Context, server *Server, req RPCRequest) {
 	var params DocumentSymbolParams
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil {
-		sendError(req.ID, -32602, "Invalid params", nil)
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	workspace, filePath, err := server.owningWorkspace(params.TextDocument.URI)
+	if err != nil {
+		server.out.sendError(req.ID, -32603, "Internal error", err.Error())
 		return
 	}
 
-	filePath, err := toRootRelativePath(server.rootPath, params.TextDocument.URI)
+	var entries []TagEntry
+	err = server.forEachInFile(ctx, workspace, filePath, func(entry TagEntry) {
+		entries = append(entries, entry)
+	})
 	if err != nil {
-		sendError(req.ID, -32603, "Internal error", err.Error())
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
 		return
 	}
 
-	server.mu.Lock()
-	defer server.mu.Unlock()
+	// Retrieve file content
+	content, err := server.cache.GetOrLoadFileContent(workspace.absolutePath(filePath))
+	if err != nil {
+		logWarnf("Failed to get content for file %s: %v", filePath, err)
+		server.out.sendResult(req.ID, []SymbolInformation{})
+		return
+	}
 
-	var symbols []SymbolInformation
+	if server.supportsHierarchicalSymbols() {
+		server.out.sendResult(req.ID, documentSymbolTree(entries, content, server.encoding))
+		return
+	}
 
-	for _, entry := range server.tagEntries {
-		if entry.Path != filePath {
-			continue
-		}
+	uri, err := relativePathToAbsoluteURI(workspace.rootPath, filePath)
+	if err != nil {
+		server.out.sendError(req.ID, -32603, "Internal error", err.Error())
+		return
+	}
 
+	symbols := []SymbolInformation{}
+	for _, entry := range entries {
 		kind, err := GetLSPSymbolKind(entry.Kind)
 		if err != nil {
 			// Skip symbols with unknown kinds
 			continue
 		}
 
-		uri, err := relativePathToAbsoluteURI(server.rootPath, entry.Path)
-		if err != nil {
-			log.Printf("Failed to build URI for %s: %v", entry.Path, err)
-			continue
-		}
-
-		// Retrieve file content
-		content, err := server.cache.GetOrLoadFileContent(entry.Path)
-		if err != nil {
-			log.Printf("Failed to get content for file %s: %v", entry.Path, err)
-			continue
-		}
-
 		// Find the symbol's range within the file
-		symbolRange := findSymbolRangeInFile(content, entry.Name, entry.Line)
+		symbolRange := findSymbolRangeInFile(content, entry.Name, entry.Line, server.encoding)
 
 		symbol := SymbolInformation{
 			Name:          entry.Name,
@@ -950,7 +1223,7 @@ func handleDocumentSymbol(server *Server, req RPCRequest) {
 		symbols = append(symbols, symbol)
 	}
 
-	sendResult(req.ID, symbols)
+	server.out.sendResult(req.ID, symbols)
 }
 
 // readFileLines reads the content of a file and returns it as a slice of lines
@@ -965,7 +1238,8 @@ func readFileLines(filePath string) ([]string, error) {
 }
 
 // findSymbolRangeInFile searches for the symbol in the specified line and returns its range
-func findSymbolRangeInFile(lines []string, symbolName string, lineNumber int) Range {
+// in the given position encoding
+func findSymbolRangeInFile(lines []string, symbolName string, lineNumber int, encoding string) Range {
 	// Adjust line number to zero-based index
 	lineIdx := lineNumber - 1
 	if lineIdx < 0 || lineIdx >= len(lines) {
@@ -977,17 +1251,18 @@ func findSymbolRangeInFile(lines []string, symbolName string, lineNumber int) Ra
 	}
 
 	lineContent := lines[lineIdx]
-	startChar := strings.Index(lineContent, symbolName)
+	startChar := symbolOffset(lineContent, symbolName)
 	if startChar == -1 {
 		// Symbol not found in the expected line; default to line start
 		return Range{
 			Start: Position{Line: lineIdx, Character: 0},
-			End:   Position{Line: lineIdx, Character: len([]rune(lineContent))},
+			End:   Position{Line: lineIdx, Character: byteOffsetToCharacter(lineContent, len(lineContent), encoding)},
 		}
 	}
 
-	// Calculate the end character position
-	endChar := startChar + len([]rune(symbolName))
+	// Convert the byte offsets to character positions
+	endChar := byteOffsetToCharacter(lineContent, startChar+len(symbolName), encoding)
+	startChar = byteOffsetToCharacter(lineContent, startChar, encoding)
 
 	return Range{
 		Start: Position{Line: lineIdx, Character: startChar},
@@ -995,40 +1270,32 @@ func findSymbolRangeInFile(lines []string, symbolName string, lineNumber int) Ra
 	}
 }
 
-// sendResult sends a successful JSON-RPC response
-func sendResult(id json.RawMessage, result any) {
-	response := RPCSuccessResponse{
-		Jsonrpc: "2.0",
-		ID:      id,
-		Result:  result,
-	}
-	sendResponse(response)
-}
-
-// sendError sends an error JSON-RPC response
-func sendError(id json.RawMessage, code int, message string, data any) {
-	response := RPCErrorResponse{
-		Jsonrpc: "2.0",
-		ID:      id,
-		Error: &RPCError{
-			Code:    code,
-			Message: message,
-			Data:    data,
-		},
+// symbolOffset returns the byte offset of a symbol's name in the line that defines it, or
+// -1. Whole words outside of brackets are preferred, so that the name of a method like
+// `func (s *Server) Server()` is found after its receiver rather than in it.
+func symbolOffset(line, name string) int {
+	firstWord := -1
+	depth := 0
+	for i := 0; i < len(line); i++ {
+		if strings.HasPrefix(line[i:], name) && isWordBoundary(line, i, i+len(name)) {
+			if depth == 0 {
+				return i
+			}
+			if firstWord < 0 {
+				firstWord = i
+			}
+		}
+		switch line[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth = max(0, depth-1)
+		}
 	}
-	sendResponse(response)
-}
-
-// sendResponse marshals and sends the JSON-RPC response with appropriate headers
-func sendResponse(resp any) {
-	body, err := json.Marshal(resp)
-	if err != nil {
-		log.Printf("Error marshaling response: %v", err)
-		return
+	if firstWord >= 0 {
+		return firstWord
 	}
-
-	// Write headers followed by the JSON body
-	fmt.Printf("Content-Length: %d\r\n\r\n%s", len(body), string(body))
+	return strings.Index(line, name)
 }
 
 // toRootRelativePath converts file URIs, absolute, or relative paths to a root-relative path.
@@ -1082,85 +1349,144 @@ func relativePathToAbsoluteURI(rootPath, rel string) (string, error) {
 	return "file://" + filepath.ToSlash(absPath), nil
 }
 
-func (s *Server) ctagsArgs(extra ...string) []string {
-	args := []string{"--output-format=json", "--fields=+n"}
-	if s.languages != "" {
-		args = append(args, "--languages="+s.languages)
+func (w *Workspace) ctagsArgs(extra ...string) []string {
+	args := []string{"--output-format=json", "--fields=+neSaimrE"}
+	if w.settings.Languages != "" {
+		args = append(args, "--languages="+w.settings.Languages)
 	}
+	for _, pattern := range w.settings.Exclude {
+		args = append(args, "--exclude="+pattern)
+	}
+	args = append(args, w.settings.ExtraArgs...)
 	return append(args, extra...)
 }
 
+// scanChunkSize caps the number of files per ctags process, so progress advances steadily
+const scanChunkSize = 200
+
 // scanWorkspace runs ctags on the workspace using parallel chunks for performance.
-func (s *Server) scanWorkspace() error {
-	if s.tagfilePath != "" {
-		tagsPath := s.tagfilePath
+// Entries become visible as chunks finish, and w.progress counts the files done.
+func (w *Workspace) scanWorkspace() error {
+	if w.settings.Tagfile != "" {
+		tagsPath := w.settings.Tagfile
 		if !filepath.IsAbs(tagsPath) {
-			tagsPath = filepath.Join(s.rootPath, tagsPath)
+			tagsPath = filepath.Join(w.rootPath, tagsPath)
 		}
 		tagsPath = filepath.Clean(tagsPath)
 		if _, err := os.Stat(tagsPath); err != nil {
 			return fmt.Errorf("tagfile not found at %q: %v", tagsPath, err)
 		}
-		entries, err := parseTagfile(tagsPath, s.rootPath)
-		if err != nil {
-			return err
-		}
-
-		s.mu.Lock()
-		s.tagEntries = append(s.tagEntries, entries...)
-		s.mu.Unlock()
-		return nil
+		return w.loadTagfile(tagsPath)
 	}
 
-	if tagsPath, found := findTagsFile(s.rootPath); found {
-		entries, err := parseTagfile(tagsPath, s.rootPath)
-		if err != nil {
-			return err
-		}
-
-		s.mu.Lock()
-		s.tagEntries = append(s.tagEntries, entries...)
-		s.mu.Unlock()
-		return nil
+	if tagsPath, found := findTagsFile(w.rootPath); found {
+		return w.loadTagfile(tagsPath)
 	}
 
-	files, err := listWorkspaceFiles(s.rootPath)
+	listed, err := listWorkspaceFiles(w.rootPath)
 	if err != nil {
 		return err
 	}
 
+	// ctags doesn't apply --exclude to files listed on stdin
+	files := make([]string, 0, len(listed))
+	for _, file := range listed {
+		if !w.settings.excluded(file) {
+			files = append(files, file)
+		}
+	}
+	w.progress.total.Store(int64(len(files)))
+
+	// Only scan files that changed since the cache was written
+	var cache *indexCache
+	var states map[string]fileState
+	if w.cacheDir != "" {
+		var reused []TagEntry
+		cache = w.loadIndexCache()
+		reused, files, states = cache.reuse(w.rootPath, files)
+		w.addEntries(reused)
+		w.progress.done.Store(w.progress.total.Load() - int64(len(files)))
+		logInfof("Reusing %d of %d files from the index cache for %s", w.progress.done.Load(), w.progress.total.Load(), w.rootPath)
+	}
+
 	workers := runtime.NumCPU()
-	size := (len(files) + workers - 1) / workers // calculate chunk size
+	size := max(1, min(scanChunkSize, (len(files)+workers-1)/workers)) // calculate chunk size
+	chunks := make(chan []string)
 	var wg sync.WaitGroup
+	var statesMu sync.Mutex
 
-	// start workers on file chunks
-	for i := range workers {
-		start := i * size
-		if start >= len(files) {
-			break
-		}
-		end := min(start+size, len(files))
-		chunk := files[start:end]
-
+	// start workers that take chunks until there are none left
+	for range workers {
 		wg.Add(1)
-		go func(chunk []string) {
+		go func() {
 			defer wg.Done()
-
-			// run ctags with input from chunk
-			cmd := exec.Command(s.ctagsBin, s.ctagsArgs("-L", "-")...)
-			cmd.Dir = s.rootPath
-			cmd.Stdin = strings.NewReader(strings.Join(chunk, "\n"))
-
-			if err := s.processTagsOutput(cmd); err != nil {
-				log.Printf("ctags error: %v", err)
+			for chunk := range chunks {
+				// run ctags with input from chunk
+				cmd := exec.Command(w.settings.CtagsBin, w.ctagsArgs("-L", "-")...)
+				cmd.Dir = w.rootPath
+				cmd.Stdin = strings.NewReader(strings.Join(chunk, "\n"))
+
+				if err := w.processTagsOutput(cmd); err != nil {
+					logErrorf("ctags error: %v", err)
+
+					// Leave the chunk out of the cache, so it is scanned again next time
+					statesMu.Lock()
+					for _, file := range chunk {
+						delete(states, file)
+					}
+					statesMu.Unlock()
+				}
+				w.progress.done.Add(int64(len(chunk)))
 			}
-		}(chunk)
+		}()
+	}
+
+	for start := 0; start < len(files) && !w.stopped.Load(); start += size {
+		chunks <- files[start:min(start+size, len(files))]
 	}
+	close(chunks)
 
 	wg.Wait() // wait for all workers
+
+	if cache != nil && !w.stopped.Load() {
+		w.saveIndexCache(cache, states)
+	}
 	return nil
 }
 
+// loadTagfile reads the entries of a tagfile into the workspace
+func (w *Workspace) loadTagfile(tagsPath string) error {
+	entries, err := parseTagfile(tagsPath, w.rootPath)
+	if err != nil {
+		return err
+	}
+	entries = w.withoutExcluded(entries)
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		files[entry.Path] = true
+	}
+	w.progress.total.Store(int64(len(files)))
+	w.progress.done.Store(int64(len(files)))
+
+	w.addEntries(entries)
+	return nil
+}
+
+// withoutExcluded drops the entries of excluded files and kinds
+func (w *Workspace) withoutExcluded(entries []TagEntry) []TagEntry {
+	if len(w.settings.Exclude) == 0 && len(w.settings.ExcludeKinds) == 0 {
+		return entries
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !w.settings.excluded(entry.Path) && !w.settings.excludedKind(entry.Kind) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
 // listWorkspaceFiles returns a list of relative file paths using git, jj, or a directory walk.
 func listWorkspaceFiles(root string) ([]string, error) {
 	// check git repo
@@ -1241,52 +1567,119 @@ func isJjRepo(path string) bool {
 	return cmd.Run() == nil
 }
 
-// scanSingleFileTag scans a single file, removing previous entries for that file
-func (s *Server) scanSingleFileTag(filePath string) error {
+// scanSingleFileTag scans a single file, replacing previous entries for that file
+func (w *Workspace) scanSingleFileTag(filePath string) error {
 	if strings.HasPrefix(filePath, "..") {
 		return fmt.Errorf("path outside root: %s", filePath)
 	}
 
-	s.mu.Lock()
-	// Remove previous entries for that file
-	newEntries := make([]TagEntry, 0, len(s.tagEntries))
-	for _, entry := range s.tagEntries {
-		if entry.Path != filePath {
-			newEntries = append(newEntries, entry)
+	return w.rescanFiles([]string{filePath})
+}
+
+// rescanFiles runs ctags on root-relative files and replaces their entries. Excluded files
+// and files without tags end up with no entries.
+func (w *Workspace) rescanFiles(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	var scan []string
+	for _, file := range files {
+		if !w.settings.excluded(file) {
+			scan = append(scan, file)
+		}
+	}
+
+	var entries []TagEntry
+	if len(scan) > 0 {
+		cmd := exec.Command(w.settings.CtagsBin, w.ctagsArgs("-L", "-")...)
+		cmd.Dir = w.rootPath
+		cmd.Stdin = strings.NewReader(strings.Join(scan, "\n"))
+		var err error
+		if entries, err = w.runCtags(cmd); err != nil {
+			return err
 		}
 	}
-	s.tagEntries = newEntries
-	s.mu.Unlock()
 
-	cmd := exec.Command(s.ctagsBin, s.ctagsArgs(filePath)...)
-	cmd.Dir = s.rootPath
-	return s.processTagsOutput(cmd)
+	byFile := make(map[string][]TagEntry, len(files))
+	for _, entry := range entries {
+		byFile[entry.Path] = append(byFile[entry.Path], entry)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.markRescanned(files)
+	for _, file := range files {
+		w.symbols.replaceFile(file, byFile[file])
+	}
+	return nil
 }
 
-// processTagsOutput handles the ctags command execution and output processing
-func (s *Server) processTagsOutput(cmd *exec.Cmd) error {
+// removeFiles drops the entries of deleted root-relative files. A deleted directory is
+// reported as a single path, so the entries of every file below it are dropped as well.
+func (w *Workspace) removeFiles(files []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var indexed []string // sorted, listed once a path may have been a directory
+	removed := make([]string, 0, len(files))
+	for _, file := range files {
+		removed = append(removed, file)
+		if _, ok := w.symbols.byFile[file]; ok {
+			continue // A tagged file, not a directory
+		}
+
+		if indexed == nil {
+			indexed = sortedKeys(w.symbols.byFile)
+		}
+		prefix := file + "/"
+		for i := sort.SearchStrings(indexed, prefix); i < len(indexed) && strings.HasPrefix(indexed[i], prefix); i++ {
+			removed = append(removed, indexed[i])
+		}
+	}
+
+	w.markRescanned(removed)
+	for _, file := range removed {
+		w.symbols.removeFile(file)
+	}
+}
+
+// processTagsOutput runs ctags and adds the entries it outputs to the workspace
+func (w *Workspace) processTagsOutput(cmd *exec.Cmd) error {
+	entries, err := w.runCtags(cmd)
+	if err != nil {
+		return err
+	}
+	w.addEntries(entries)
+	return nil
+}
+
+// runCtags handles the ctags command execution and output processing
+func (w *Workspace) runCtags(cmd *exec.Cmd) ([]TagEntry, error) {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to get stdout from ctags command: %v", err)
+		return nil, fmt.Errorf("failed to get stdout from ctags command: %v", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start ctags command: %v", err)
+		return nil, fmt.Errorf("failed to start ctags command: %v", err)
 	}
+	w.children.add(cmd)
+	defer w.children.remove(cmd)
 
 	scanner := bufio.NewScanner(stdout)
 	var entries []TagEntry
 	for scanner.Scan() {
 		var entry TagEntry
 		if err := json.Unmarshal([]byte(scanner.Text()), &entry); err != nil {
-			log.Printf("Failed to parse ctags JSON entry: %v", err)
+			logWarnf("Failed to parse ctags JSON entry: %v", err)
 			continue
 		}
 
 		// Normalize the Path to be relative to rootPath
-		relPath, err := toRootRelativePath(s.rootPath, entry.Path)
+		relPath, err := toRootRelativePath(w.rootPath, entry.Path)
 		if err != nil {
-			log.Printf("Failed to make path relative for %s: %v", entry.Path, err)
+			logWarnf("Failed to make path relative for %s: %v", entry.Path, err)
 			continue
 		}
 		entry.Path = relPath
@@ -1295,26 +1688,26 @@ func (s *Server) processTagsOutput(cmd *exec.Cmd) error {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading ctags output: %v", err)
+		return nil, fmt.Errorf("error reading ctags output: %v", err)
 	}
 
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("ctags command failed: %v", err)
+		return nil, fmt.Errorf("ctags command failed: %v", err)
 	}
 
-	s.mu.Lock()
-	s.tagEntries = append(s.tagEntries, entries...)
-	s.mu.Unlock()
-
-	return nil
+	return w.withoutExcluded(entries), nil
 }
 
 // getCurrentWord retrieves the current word at the given position in the document
-// using a root-relative file path.
-func (s *Server) getCurrentWord(filePath string, pos Position) (string, error) {
-	lines, err := s.cache.GetOrLoadFileContent(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to load file content: %v", err)
+// using the document's filesystem path.
+func (s *Server) getCurrentWord(ctx context.Context, filePath string, pos Position) (string, error) {
+	lines, ok := s.openDocumentLines(ctx, filePath)
+	if !ok {
+		var err error
+		lines, err = s.cache.GetOrLoadFileContent(filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load file content: %v", err)
+		}
 	}
 
 	if pos.Line >= len(lines) {
@@ -1322,27 +1715,35 @@ func (s *Server) getCurrentWord(filePath string, pos Position) (string, error) {
 	}
 
 	line := lines[pos.Line]
-	runes := []rune(line)
-	if pos.Character > len(runes) {
+	offset, ok := characterToByteOffset(line, pos.Character, s.encoding)
+	if !ok {
 		return "", fmt.Errorf("character %d out of range", pos.Character)
 	}
 
 	// Find word boundaries
-	start := pos.Character
-	for start > 0 && isIdentifierChar(runes[start-1]) {
-		start--
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isIdentifierChar(r) {
+			break
+		}
+		start -= size
 	}
 
-	end := pos.Character
-	for end < len(runes) && isIdentifierChar(runes[end]) {
-		end++
+	end := offset
+	for end < len(line) {
+		r, size := utf8.DecodeRuneInString(line[end:])
+		if !isIdentifierChar(r) {
+			break
+		}
+		end += size
 	}
 
 	if start == end {
 		return "", fmt.Errorf("no word found at position")
 	}
 
-	word := string(runes[start:end])
+	word := line[start:end]
 	return word, nil
 }
 
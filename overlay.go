@@ -0,0 +1,309 @@
+// overlay re-tags the unsaved buffers of open documents. Once edits settle, the buffer
+// from FileCache is written to a temporary file and run through ctags, and the entries
+// found stand in for the file's on-disk entries until the document is closed. Overlays
+// belong to the connection, so other clients sharing a workspace index never see them.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retagDelay is how long a document must stay unchanged before its buffer is re-tagged
+const retagDelay = 300 * time.Millisecond
+
+// ctagsLanguages maps LSP language identifiers to ctags language names
+var ctagsLanguages = map[string]string{
+	"ada":             "Ada",
+	"asm":             "Asm",
+	"c":               "C",
+	"clojure":         "Clojure",
+	"cmake":           "CMake",
+	"cpp":             "C++",
+	"csharp":          "C#",
+	"css":             "CSS",
+	"d":               "D",
+	"dockerfile":      "Dockerfile",
+	"elixir":          "Elixir",
+	"elm":             "Elm",
+	"erlang":          "Erlang",
+	"fortran":         "Fortran",
+	"go":              "Go",
+	"haskell":         "Haskell",
+	"html":            "HTML",
+	"java":            "Java",
+	"javascript":      "JavaScript",
+	"javascriptreact": "JavaScript",
+	"json":            "JSON",
+	"julia":           "Julia",
+	"kotlin":          "Kotlin",
+	"latex":           "Tex",
+	"lua":             "Lua",
+	"makefile":        "Make",
+	"markdown":        "Markdown",
+	"objective-c":     "ObjectiveC",
+	"ocaml":           "OCaml",
+	"pascal":          "Pascal",
+	"perl":            "Perl",
+	"php":             "PHP",
+	"powershell":      "PowerShell",
+	"python":          "Python",
+	"r":               "R",
+	"ruby":            "Ruby",
+	"rust":            "Rust",
+	"scala":           "Scala",
+	"scss":            "SCSS",
+	"shellscript":     "Sh",
+	"sql":             "SQL",
+	"systemverilog":   "SystemVerilog",
+	"tcl":             "Tcl",
+	"tex":             "Tex",
+	"typescript":      "TypeScript",
+	"typescriptreact": "TypeScript",
+	"verilog":         "Verilog",
+	"vhdl":            "VHDL",
+	"vim":             "Vim",
+	"yaml":            "Yaml",
+	"zig":             "Zig",
+}
+
+// openDocument tracks a document opened by the client
+type openDocument struct {
+	languageID string
+	version    int         // counts buffer changes, so outdated re-tags are dropped
+	timer      *time.Timer // pending re-tag, nil if none
+}
+
+// overlay holds the entries of the unsaved buffers of a workspace folder
+type overlay struct {
+	symbols symbolStore
+	files   map[string]struct{} // root-relative files whose on-disk entries are hidden
+}
+
+// bufferOverlays holds the open documents of a connection and the overlays built from them
+type bufferOverlays struct {
+	mu        sync.Mutex
+	documents map[string]*openDocument // by filesystem path
+	roots     map[string]*overlay      // by workspace root path
+}
+
+// newBufferOverlays creates empty buffer overlays
+func newBufferOverlays() bufferOverlays {
+	return bufferOverlays{
+		documents: make(map[string]*openDocument),
+		roots:     make(map[string]*overlay),
+	}
+}
+
+// hides reports whether the overlay stands in for a root-relative file. A nil overlay hides nothing.
+func (o *overlay) hides(path string) bool {
+	if o == nil {
+		return false
+	}
+	_, ok := o.files[path]
+	return ok
+}
+
+// replace sets the entries of a root-relative file, creating the folder's overlay if needed.
+func (b *bufferOverlays) replace(rootPath, path string, entries []TagEntry) {
+	o, ok := b.roots[rootPath]
+	if !ok {
+		o = &overlay{symbols: newSymbolStore(), files: make(map[string]struct{})}
+		b.roots[rootPath] = o
+	}
+	o.symbols.replaceFile(path, entries)
+	o.files[path] = struct{}{}
+}
+
+// remove drops a root-relative file from the folder's overlay, so its on-disk entries show again.
+func (b *bufferOverlays) remove(rootPath, path string) {
+	o, ok := b.roots[rootPath]
+	if !ok {
+		return
+	}
+	o.symbols.removeFile(path)
+	delete(o.files, path)
+	if len(o.files) == 0 {
+		delete(b.roots, rootPath)
+	}
+}
+
+// openBuffer starts tracking a document. Buffers that differ from the file on disk, such as
+// new unsaved files, are re-tagged right away.
+func (s *Server) openBuffer(filePath, languageID, text string) {
+	s.buffers.mu.Lock()
+	if doc, ok := s.buffers.documents[filePath]; ok && doc.timer != nil {
+		doc.timer.Stop()
+	}
+	s.buffers.documents[filePath] = &openDocument{languageID: languageID}
+	s.buffers.mu.Unlock()
+
+	if data, err := os.ReadFile(filePath); err != nil || string(data) != text {
+		s.scheduleRetag(filePath)
+	}
+}
+
+// closeBuffer stops tracking a document and drops its overlay.
+func (s *Server) closeBuffer(filePath string) {
+	s.buffers.mu.Lock()
+	defer s.buffers.mu.Unlock()
+
+	if doc, ok := s.buffers.documents[filePath]; ok && doc.timer != nil {
+		doc.timer.Stop()
+	}
+	delete(s.buffers.documents, filePath)
+
+	for rootPath, o := range s.buffers.roots {
+		if rel, err := toRootRelativePath(rootPath, filePath); err == nil && o.hides(rel) {
+			s.buffers.remove(rootPath, rel)
+		}
+	}
+}
+
+// openDocuments returns the filesystem paths of the documents open in the client.
+func (s *Server) openDocuments() []string {
+	s.buffers.mu.Lock()
+	defer s.buffers.mu.Unlock()
+	return sortedKeys(s.buffers.documents)
+}
+
+// bufferLines returns the unsaved content of a document if it is open in the client.
+func (s *Server) bufferLines(filePath string) ([]string, bool) {
+	s.buffers.mu.Lock()
+	_, open := s.buffers.documents[filePath]
+	s.buffers.mu.Unlock()
+	if !open {
+		return nil, false
+	}
+	return s.cache.GetCachedContent(filePath)
+}
+
+// documentLines returns the content of a file, from the editor buffer if it is open.
+func (s *Server) documentLines(filePath string) ([]string, error) {
+	if lines, ok := s.bufferLines(filePath); ok {
+		return lines, nil
+	}
+	return readFileLines(filePath)
+}
+
+// scheduleRetag re-tags the buffer of an open document once it has been left unchanged for
+// retagDelay. Every call restarts the delay.
+func (s *Server) scheduleRetag(filePath string) {
+	s.buffers.mu.Lock()
+	defer s.buffers.mu.Unlock()
+
+	doc, ok := s.buffers.documents[filePath]
+	if !ok {
+		return
+	}
+	doc.version++
+	version := doc.version
+	if doc.timer != nil {
+		doc.timer.Stop()
+	}
+	doc.timer = time.AfterFunc(retagDelay, func() {
+		s.retagBuffer(filePath, version)
+	})
+}
+
+// retagOpenDocuments re-tags every buffer with an overlay in a workspace folder, after
+// the folder was re-indexed with new settings.
+func (s *Server) retagOpenDocuments(rootPath string) {
+	s.buffers.mu.Lock()
+	var paths []string
+	if o, ok := s.buffers.roots[rootPath]; ok {
+		for rel := range o.files {
+			paths = append(paths, filepath.Join(rootPath, filepath.FromSlash(rel)))
+		}
+	}
+	s.buffers.mu.Unlock()
+
+	for _, filePath := range paths {
+		s.scheduleRetag(filePath)
+	}
+}
+
+// retagBuffer runs ctags on the cached buffer of a document and makes the entries its
+// overlay, unless the document changed or was closed in the meantime.
+func (s *Server) retagBuffer(filePath string, version int) {
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+
+	current := func() (*openDocument, bool) {
+		doc, ok := s.buffers.documents[filePath]
+		return doc, ok && doc.version == version
+	}
+
+	s.buffers.mu.Lock()
+	doc, ok := current()
+	s.buffers.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	workspace, rel, err := s.owningWorkspace(filePath)
+	if err != nil {
+		return // Documents outside the workspace folders are not indexed
+	}
+	lines, ok := s.cache.GetCachedContent(filePath)
+	if !ok {
+		return
+	}
+
+	entries, err := workspace.tagBuffer(rel, doc.languageID, lines)
+	if err != nil {
+		logErrorf("Error tagging buffer %s: %v", filePath, err)
+		return
+	}
+
+	s.buffers.mu.Lock()
+	defer s.buffers.mu.Unlock()
+	if _, ok := current(); ok {
+		s.buffers.replace(workspace.rootPath, rel, entries)
+	}
+}
+
+// tagBuffer runs ctags on the content of a root-relative file that isn't saved yet. The
+// language is forced from the LSP language identifier if ctags knows it, and otherwise
+// detected from the file name as usual.
+func (w *Workspace) tagBuffer(rel, languageID string, lines []string) ([]TagEntry, error) {
+	if w.settings.excluded(rel) {
+		return nil, nil
+	}
+
+	dir, err := os.MkdirTemp("", "ctags-lsp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Keep the file name, ctags detects some languages from it
+	name := filepath.Base(filepath.FromSlash(rel))
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write buffer: %v", err)
+	}
+
+	args := w.ctagsArgs()
+	if language, ok := ctagsLanguages[languageID]; ok {
+		args = append(args, "--language-force="+language)
+	}
+	cmd := exec.Command(w.settings.CtagsBin, append(args, name)...)
+	cmd.Dir = dir
+
+	entries, err := w.runCtags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Path = rel
+	}
+	return entries, nil
+}
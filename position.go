@@ -0,0 +1,78 @@
+// position converts between byte offsets in a line and LSP character positions, which
+// count code units of the position encoding negotiated with the client.
+package main
+
+import (
+	"unicode/utf8"
+)
+
+// Position encodings as defined by the LSP specification
+const (
+	positionEncodingUTF8  = "utf-8"
+	positionEncodingUTF16 = "utf-16"
+	positionEncodingUTF32 = "utf-32"
+)
+
+// negotiatePositionEncoding picks the first encoding offered by the client that the server
+// supports. Without an offer the specification mandates UTF-16.
+func negotiatePositionEncoding(offered []string) string {
+	for _, encoding := range offered {
+		switch encoding {
+		case positionEncodingUTF8, positionEncodingUTF16, positionEncodingUTF32:
+			return encoding
+		}
+	}
+	return positionEncodingUTF16
+}
+
+// runeWidth returns the number of code units a rune occupies in an encoding
+func runeWidth(r rune, size int, encoding string) int {
+	switch encoding {
+	case positionEncodingUTF8:
+		return size
+	case positionEncodingUTF32:
+		return 1
+	default:
+		if r >= 0x10000 {
+			return 2 // surrogate pair
+		}
+		return 1
+	}
+}
+
+// byteOffsetToCharacter converts a byte offset in line to a character position.
+func byteOffsetToCharacter(line string, offset int, encoding string) int {
+	offset = min(max(offset, 0), len(line))
+	if encoding == positionEncodingUTF8 {
+		return offset
+	}
+
+	character := 0
+	for i := 0; i < offset; {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		character += runeWidth(r, size, encoding)
+		i += size
+	}
+	return character
+}
+
+// characterToByteOffset converts a character position in line to a byte offset. A position
+// inside a multi-unit character resolves to the start of that character. It reports false
+// if the position lies beyond the end of the line.
+func characterToByteOffset(line string, character int, encoding string) (int, bool) {
+	if character < 0 {
+		return 0, false
+	}
+
+	units := 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		width := runeWidth(r, size, encoding)
+		if units+width > character {
+			return i, true
+		}
+		units += width
+		i += size
+	}
+	return len(line), units == character
+}
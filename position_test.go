@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNegotiatePositionEncoding(t *testing.T) {
+	tests := []struct {
+		offered []string
+		want    string
+	}{
+		{nil, positionEncodingUTF16},
+		{[]string{}, positionEncodingUTF16},
+		{[]string{"utf-8"}, positionEncodingUTF8},
+		{[]string{"utf-32", "utf-8"}, positionEncodingUTF32},
+		{[]string{"latin-1", "utf-8", "utf-16"}, positionEncodingUTF8},
+		{[]string{"latin-1"}, positionEncodingUTF16},
+	}
+
+	for _, tt := range tests {
+		if got := negotiatePositionEncoding(tt.offered); got != tt.want {
+			t.Errorf("negotiatePositionEncoding(%q) = %q, want %q", tt.offered, got, tt.want)
+		}
+	}
+}
+
+// mixedWidthLine mixes one, two, three and four byte characters: "a" (1 byte), "é" (2 bytes),
+// "€" (3 bytes) and "😀" (4 bytes, a surrogate pair in UTF-16).
+const mixedWidthLine = "aé€😀b"
+
+func TestByteOffsetToCharacter(t *testing.T) {
+	tests := []struct {
+		offset int
+		utf8   int
+		utf16  int
+		utf32  int
+	}{
+		{0, 0, 0, 0},
+		{1, 1, 1, 1},   // before é
+		{3, 3, 2, 2},   // before €
+		{6, 6, 3, 3},   // before 😀
+		{10, 10, 5, 4}, // before b
+		{11, 11, 6, 5}, // end of line
+		{-1, 0, 0, 0},  // clamped to the start
+		{99, 11, 6, 5}, // clamped to the end
+	}
+
+	for _, tt := range tests {
+		for encoding, want := range map[string]int{
+			positionEncodingUTF8:  tt.utf8,
+			positionEncodingUTF16: tt.utf16,
+			positionEncodingUTF32: tt.utf32,
+		} {
+			if got := byteOffsetToCharacter(mixedWidthLine, tt.offset, encoding); got != want {
+				t.Errorf("byteOffsetToCharacter(%d, %s) = %d, want %d", tt.offset, encoding, got, want)
+			}
+		}
+	}
+}
+
+func TestCharacterToByteOffset(t *testing.T) {
+	tests := []struct {
+		encoding  string
+		character int
+		want      int
+		ok        bool
+	}{
+		{positionEncodingUTF16, 0, 0, true},
+		{positionEncodingUTF16, 2, 3, true},
+		{positionEncodingUTF16, 3, 6, true},
+		{positionEncodingUTF16, 4, 6, true}, // inside the surrogate pair
+		{positionEncodingUTF16, 5, 10, true},
+		{positionEncodingUTF16, 6, 11, true}, // end of line
+		{positionEncodingUTF16, 7, 11, false},
+		{positionEncodingUTF16, -1, 0, false},
+
+		{positionEncodingUTF32, 3, 6, true},
+		{positionEncodingUTF32, 4, 10, true},
+		{positionEncodingUTF32, 5, 11, true},
+		{positionEncodingUTF32, 6, 11, false},
+
+		{positionEncodingUTF8, 2, 1, true}, // inside é
+		{positionEncodingUTF8, 3, 3, true},
+		{positionEncodingUTF8, 8, 6, true}, // inside 😀
+		{positionEncodingUTF8, 11, 11, true},
+		{positionEncodingUTF8, 12, 11, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := characterToByteOffset(mixedWidthLine, tt.character, tt.encoding)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("characterToByteOffset(%d, %s) = %d, %v, want %d, %v", tt.character, tt.encoding, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPositionRoundTrip(t *testing.T) {
+	lines := []string{"", "plain ascii", mixedWidthLine, "\t// 日本語 comment", "🎉🎉🎉", "invalid \xff byte"}
+	encodings := []string{positionEncodingUTF8, positionEncodingUTF16, positionEncodingUTF32}
+
+	for _, text := range lines {
+		for _, encoding := range encodings {
+			for offset := range len(text) + 1 {
+				if offset < len(text) && !utf8.RuneStart(text[offset]) {
+					continue // Not a character boundary
+				}
+				character := byteOffsetToCharacter(text, offset, encoding)
+				back, ok := characterToByteOffset(text, character, encoding)
+				if !ok || back != offset {
+					t.Errorf("%q, %s: offset %d became character %d and offset %d, %v", text, encoding, offset, character, back, ok)
+				}
+			}
+		}
+	}
+}
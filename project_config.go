@@ -0,0 +1,300 @@
+// project_config loads settings checked into a workspace as .ctags-lsp.json or
+// .ctags-lsp.toml. The TOML reader supports the subset needed for Settings: top-level
+// keys with string or string array values, and comments.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Project configuration file names, in order of preference
+var projectConfigFiles = []string{".ctags-lsp.json", ".ctags-lsp.toml"}
+
+// isProjectConfigFile reports whether a root-relative path is a project configuration file.
+func isProjectConfigFile(rel string) bool {
+	for _, name := range projectConfigFiles {
+		if rel == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadProjectSettings reads the project configuration file of a workspace root.
+// A missing file results in empty settings.
+func loadProjectSettings(rootPath string) (Settings, error) {
+	for _, name := range projectConfigFiles {
+		path := filepath.Join(rootPath, name)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Settings{}, err
+		}
+
+		var settings Settings
+		if strings.HasSuffix(name, ".toml") {
+			settings, err = parseTOMLSettings(data)
+		} else {
+			settings, err = parseJSONSettings(data)
+		}
+		if err != nil {
+			return Settings{}, fmt.Errorf("%s: %v", path, err)
+		}
+
+		// A checked-in file must not be able to run arbitrary programs
+		if settings.CtagsBin != "" {
+			logWarnf("Ignoring ctagsBin in %s, set it on the command line or in the editor", path)
+			settings.CtagsBin = ""
+		}
+		if settings.Tagfile != "" && !withinRoot(rootPath, settings.Tagfile) {
+			logWarnf("Ignoring tagfile %q in %s, it must be inside the workspace", settings.Tagfile, path)
+			settings.Tagfile = ""
+		}
+		settings.ExtraArgs = projectExtraArgs(settings.ExtraArgs, path)
+		settings.Exclude = projectExclude(settings.Exclude, path)
+		return settings, nil
+	}
+	return Settings{}, nil
+}
+
+// projectArgPrefixes are the ctags options a project file may pass in extraArgs. They only
+// select what is tagged; options that read or write other files, like -o and --options,
+// are left to the command line and the editor.
+var projectArgPrefixes = []string{"--kinds-", "--extras=", "--extras-", "--fields=", "--fields-", "--langmap=", "--map-"}
+
+// projectExtraArgs returns the extraArgs of a project file that start with one of
+// projectArgPrefixes, warning about the others.
+func projectExtraArgs(args []string, path string) []string {
+	if args == nil {
+		return nil
+	}
+	allowed := []string{}
+	for _, arg := range args {
+		vetted := false
+		for _, prefix := range projectArgPrefixes {
+			if strings.HasPrefix(arg, prefix) && strings.Contains(arg, "=") {
+				vetted = true
+				break
+			}
+		}
+		if !vetted {
+			logWarnf("Ignoring extraArgs %q in %s, project files may only set kinds, extras, fields and language maps", arg, path)
+			continue
+		}
+		allowed = append(allowed, arg)
+	}
+	return allowed
+}
+
+// projectExclude returns the exclude patterns of a project file without those starting
+// with "@", which ctags treats as the name of a file to read patterns from.
+func projectExclude(patterns []string, path string) []string {
+	if patterns == nil {
+		return nil
+	}
+	allowed := []string{}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "@") {
+			logWarnf("Ignoring exclude %q in %s, project files can't read patterns from a file", pattern, path)
+			continue
+		}
+		allowed = append(allowed, pattern)
+	}
+	return allowed
+}
+
+// withinRoot reports whether path, absolute or relative to rootPath, is inside rootPath.
+func withinRoot(rootPath, path string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootPath, path)
+	}
+	rel, err := filepath.Rel(rootPath, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// parseJSONSettings decodes a JSON project configuration, rejecting unknown keys.
+func parseJSONSettings(data []byte) (Settings, error) {
+	var settings Settings
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&settings)
+	return settings, err
+}
+
+// parseTOMLSettings decodes a TOML project configuration into Settings.
+func parseTOMLSettings(data []byte) (Settings, error) {
+	var settings Settings
+	stringKeys := map[string]*string{
+		"ctagsBin":  &settings.CtagsBin,
+		"tagfile":   &settings.Tagfile,
+		"languages": &settings.Languages,
+	}
+	arrayKeys := map[string]*[]string{
+		"exclude":      &settings.Exclude,
+		"extraArgs":    &settings.ExtraArgs,
+		"excludeKinds": &settings.ExcludeKinds,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		key, value, ok, err := splitTOMLLine(scanner.Text())
+		if err != nil {
+			return settings, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if !ok {
+			continue
+		}
+
+		// Arrays may span several lines
+		start := lineNum
+		for strings.HasPrefix(value, "[") && !tomlArrayClosed(value) && scanner.Scan() {
+			lineNum++
+			value += " " + stripTOMLComment(scanner.Text())
+		}
+
+		if target, ok := stringKeys[key]; ok {
+			*target, err = parseTOMLString(value)
+		} else if target, ok := arrayKeys[key]; ok {
+			*target, err = parseTOMLStringArray(value)
+		} else {
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return settings, fmt.Errorf("line %d: %v", start, err)
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// splitTOMLLine splits a 'key = value' line. It reports false for blank and comment lines.
+func splitTOMLLine(line string) (string, string, bool, error) {
+	line = stripTOMLComment(line)
+	if line == "" {
+		return "", "", false, nil
+	}
+	if strings.HasPrefix(line, "[") {
+		return "", "", false, fmt.Errorf("tables are not supported")
+	}
+
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false, fmt.Errorf("expected 'key = value'")
+	}
+	key = strings.Trim(strings.TrimSpace(key), `"`)
+	return key, strings.TrimSpace(value), true, nil
+}
+
+// stripTOMLComment removes a trailing comment that isn't part of a string, and surrounding whitespace.
+func stripTOMLComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// tomlArrayClosed reports whether an array value ends with its closing bracket.
+func tomlArrayClosed(value string) bool {
+	return strings.HasSuffix(value, "]")
+}
+
+// parseTOMLString decodes a basic ("...") or literal ('...') string.
+func parseTOMLString(value string) (string, error) {
+	s, rest, err := readTOMLString(value)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	return s, nil
+}
+
+// parseTOMLStringArray decodes an array of strings, allowing a trailing comma.
+func parseTOMLStringArray(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !tomlArrayClosed(value) {
+		return nil, fmt.Errorf("expected an array of strings")
+	}
+	rest := strings.TrimSpace(value[1 : len(value)-1])
+
+	values := []string{}
+	for rest != "" {
+		s, after, err := readTOMLString(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+
+		after = strings.TrimSpace(after)
+		if after == "" {
+			break
+		}
+		if !strings.HasPrefix(after, ",") {
+			return nil, fmt.Errorf("expected ',' between array values")
+		}
+		rest = strings.TrimSpace(after[1:])
+	}
+	return values, nil
+}
+
+// readTOMLString reads a string at the start of value and returns it with the remaining input.
+func readTOMLString(value string) (string, string, error) {
+	if strings.HasPrefix(value, "'") {
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return value[1 : end+1], value[end+2:], nil
+	}
+	if !strings.HasPrefix(value, `"`) {
+		return "", "", fmt.Errorf("expected a string")
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			return b.String(), value[i+1:], nil
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(value[i])
+			default:
+				return "", "", fmt.Errorf("unsupported escape sequence \\%c", value[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
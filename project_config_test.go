@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOMLSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Settings
+	}{
+		{"empty", "", Settings{}},
+		{"comments and blank lines", "# settings\n\n   # indented\n", Settings{}},
+		{"basic string", `languages = "Go,Python"`, Settings{Languages: "Go,Python"}},
+		{"literal string", `tagfile = 'C:\tags'`, Settings{Tagfile: `C:\tags`}},
+		{"quoted key", `"languages" = "Go"`, Settings{Languages: "Go"}},
+		{"trailing comment", `languages = "Go" # only Go`, Settings{Languages: "Go"}},
+		{"hash in string", `tagfile = "a#b" # comment`, Settings{Tagfile: "a#b"}},
+		{"hash in literal string", `tagfile = 'a#b'`, Settings{Tagfile: "a#b"}},
+		{"escapes", `tagfile = "a\"b\\c\td\n"`, Settings{Tagfile: "a\"b\\c\td\n"}},
+		{"escaped quote before hash", `tagfile = "a\"#b"`, Settings{Tagfile: `a"#b`}},
+		{"array", `exclude = ["a", 'b']`, Settings{Exclude: []string{"a", "b"}}},
+		{"empty array", `exclude = []`, Settings{Exclude: []string{}}},
+		{"trailing comma", `exclude = ["a", "b",]`, Settings{Exclude: []string{"a", "b"}}},
+		{"comma in string", `extraArgs = ["--langmap=C:.c,.h"]`, Settings{ExtraArgs: []string{"--langmap=C:.c,.h"}}},
+		{
+			"multi-line array with comments",
+			"exclude = [ # generated code\n  \"vendor\", # third party\n  # \"build\",\n  \"node_modules\",\n] # done\nlanguages = \"Go\"",
+			Settings{Exclude: []string{"vendor", "node_modules"}, Languages: "Go"},
+		},
+		{
+			"bracket in multi-line array string",
+			"excludeKinds = [\n  \"a]\",\n  \"b\"\n]",
+			Settings{ExcludeKinds: []string{"a]", "b"}},
+		},
+		{
+			"all keys",
+			"ctagsBin = \"ctags\"\ntagfile = \"tags\"\nlanguages = \"Go\"\nexclude = [\"x\"]\nextraArgs = [\"--extras=+r\"]\nexcludeKinds = [\"variable\"]",
+			Settings{
+				CtagsBin:     "ctags",
+				Tagfile:      "tags",
+				Languages:    "Go",
+				Exclude:      []string{"x"},
+				ExtraArgs:    []string{"--extras=+r"},
+				ExcludeKinds: []string{"variable"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOMLSettings([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown key", `colour = "blue"`},
+		{"table", "[ctags-lsp]\nlanguages = \"Go\""},
+		{"missing equals", `languages "Go"`},
+		{"unterminated string", `languages = "Go`},
+		{"unterminated literal string", `languages = 'Go`},
+		{"unquoted value", `languages = Go`},
+		{"text after string", `languages = "Go" "Python"`},
+		{"string for array", `exclude = "vendor"`},
+		{"array for string", `languages = ["Go"]`},
+		{"missing comma", `exclude = ["a" "b"]`},
+		{"non-string element", `exclude = [1]`},
+		{"unclosed array", "exclude = [\n  \"a\","},
+		{"unsupported escape", `languages = "\u0041"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseTOMLSettings([]byte(tt.input)); err == nil {
+				t.Errorf("expected an error, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestStripTOMLComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"", ""},
+		{"  # comment", ""},
+		{`key = "value"  # comment`, `key = "value"`},
+		{`key = "a # b"`, `key = "a # b"`},
+		{`key = 'a # b' # c`, `key = 'a # b'`},
+		{`key = "it's" # c`, `key = "it's"`},
+		{`key = "a\" # b"`, `key = "a\" # b"`},
+		{`key = 'a\' # b`, `key = 'a\'`},
+	}
+
+	for _, tt := range tests {
+		if got := stripTOMLComment(tt.line); got != tt.want {
+			t.Errorf("stripTOMLComment(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONSettings(t *testing.T) {
+	got, err := parseJSONSettings([]byte(`{"languages": "Go", "exclude": ["vendor"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Settings{Languages: "Go", Exclude: []string{"vendor"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseJSONSettings([]byte(`{"colour": "blue"}`)); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestProjectExtraArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"unset", nil, nil},
+		{"empty", []string{}, []string{}},
+		{
+			"vetted",
+			[]string{"--kinds-C=+p", "--extras=+r", "--extras-Go=+q", "--fields=+K", "--fields-C=+{macrodef}", "--langmap=C:.c.h", "--map-Go=+.go2"},
+			[]string{"--kinds-C=+p", "--extras=+r", "--extras-Go=+q", "--fields=+K", "--fields-C=+{macrodef}", "--langmap=C:.c.h", "--map-Go=+.go2"},
+		},
+		{
+			"file access",
+			[]string{"-o", "../../.bashrc", "-f", "--options=/tmp/x", "--output-format=etags", "-R", "--kinds-C"},
+			[]string{},
+		},
+		{"mixed", []string{"--extras=+r", "-o", "tags"}, []string{"--extras=+r"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := projectExtraArgs(tt.args, ".ctags-lsp.toml"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectExclude(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"unset", nil, nil},
+		{"patterns", []string{"vendor", "*.min.js", "a@b"}, []string{"vendor", "*.min.js", "a@b"}},
+		{"pattern file", []string{"@/etc/passwd", "vendor", "@.gitignore"}, []string{"vendor"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := projectExclude(tt.patterns, ".ctags-lsp.toml"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithinRoot(t *testing.T) {
+	root := filepath.FromSlash("/work/project")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"tags", true},
+		{"build/tags", true},
+		{"./tags", true},
+		{"..tags", true},
+		{"../tags", false},
+		{"build/../../tags", false},
+		{filepath.FromSlash("/work/project/tags"), true},
+		{filepath.FromSlash("/work/other/tags"), false},
+		{filepath.FromSlash("/work/project-other/tags"), false},
+	}
+
+	for _, tt := range tests {
+		if got := withinRoot(root, tt.path); got != tt.want {
+			t.Errorf("withinRoot(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoadProjectSettings(t *testing.T) {
+	root := t.TempDir()
+
+	settings, err := loadProjectSettings(root)
+	if err != nil || !reflect.DeepEqual(settings, Settings{}) {
+		t.Fatalf("without a file got %+v, %v", settings, err)
+	}
+
+	toml := "ctagsBin = \"/bin/sh\"\ntagfile = \"../tags\"\nlanguages = \"Go\"\nextraArgs = [\"-o\", \"x\", \"--extras=+r\"]\n"
+	if err := os.WriteFile(filepath.Join(root, ".ctags-lsp.toml"), []byte(toml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = loadProjectSettings(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Settings{Languages: "Go", ExtraArgs: []string{"--extras=+r"}}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("got %+v, want %+v", settings, want)
+	}
+
+	// The JSON file is preferred
+	if err := os.WriteFile(filepath.Join(root, ".ctags-lsp.json"), []byte(`{"languages": "Python"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = loadProjectSettings(root)
+	if err != nil || settings.Languages != "Python" {
+		t.Errorf("got %+v, %v, want the JSON settings", settings, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, ".ctags-lsp.json"), []byte(`{`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadProjectSettings(root); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
@@ -0,0 +1,354 @@
+// record implements session recording (--record) and replay (--replay). A recording holds
+// every inbound and outbound JSON-RPC message with a timestamp, and replaying it against a
+// workspace reports every response that differs from the recorded one.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message directions in a recording
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// RecordedMessage is a single line of a session recording
+type RecordedMessage struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// Recorder appends messages to a session recording
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder creates or truncates the recording at path.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %v", err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+// record writes a message body to the recording. Bodies that aren't valid JSON are stored as strings.
+func (r *Recorder) record(direction string, body []byte) {
+	message := json.RawMessage(body)
+	if !json.Valid(body) {
+		message, _ = json.Marshal(string(body))
+	}
+
+	line, err := json.Marshal(RecordedMessage{
+		Time:      time.Now(),
+		Direction: direction,
+		Message:   message,
+	})
+	if err != nil {
+		logErrorf("Failed to encode recorded message: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Unbuffered, so the recording survives os.Exit
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		logErrorf("Failed to write recording: %v", err)
+	}
+}
+
+// loadRecording reads all messages of a session recording
+func loadRecording(path string) ([]RecordedMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []RecordedMessage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxContentLength)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var message RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return nil, fmt.Errorf("invalid recording line %d: %v", len(messages)+1, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, scanner.Err()
+}
+
+// recordedRootURI returns the root URI of the recorded initialize request, or its first
+// workspace folder
+func recordedRootURI(messages []RecordedMessage) string {
+	for _, message := range messages {
+		if message.Direction != directionIn {
+			continue
+		}
+		var req RPCRequest
+		if json.Unmarshal(message.Message, &req) != nil || req.Method != "initialize" {
+			continue
+		}
+		var params InitializeParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			continue
+		}
+		if params.RootURI == "" && len(params.WorkspaceFolders) > 0 {
+			return params.WorkspaceFolders[0].URI
+		}
+		return params.RootURI
+	}
+	return ""
+}
+
+// replayTimeout bounds how long the replay waits for a single response
+const replayTimeout = 30 * time.Second
+
+// replayCollector reads the replayed server's output and keeps responses by request ID
+type replayCollector struct {
+	mu        sync.Mutex
+	responses map[string]RPCRequest
+	arrived   chan struct{} // signaled whenever a response was stored
+	server    *Server
+}
+
+// run reads frames until the pipe is closed. Requests from the server are answered
+// with an empty result, since the recorded client answers can't be matched up reliably.
+func (c *replayCollector) run(r io.Reader) {
+	reader := NewMessageReader(r)
+	for {
+		msg, err := reader.Read()
+		if err != nil {
+			return
+		}
+		switch {
+		case msg.Method == "" && len(msg.ID) > 0:
+			c.mu.Lock()
+			c.responses[requestKey(msg.ID)] = msg
+			c.mu.Unlock()
+			select {
+			case c.arrived <- struct{}{}:
+			default:
+			}
+		case msg.Method != "" && len(msg.ID) > 0:
+			go c.server.out.deliverResponse(RPCRequest{Jsonrpc: "2.0", ID: msg.ID, Result: json.RawMessage("null")})
+		}
+	}
+}
+
+// take waits for the response to a request ID and removes it from the collector
+func (c *replayCollector) take(id json.RawMessage) (RPCRequest, bool) {
+	key := requestKey(id)
+	timeout := time.After(replayTimeout)
+	for {
+		c.mu.Lock()
+		msg, ok := c.responses[key]
+		delete(c.responses, key)
+		c.mu.Unlock()
+		if ok {
+			return msg, true
+		}
+
+		select {
+		case <-c.arrived:
+		case <-timeout:
+			return RPCRequest{}, false
+		}
+	}
+}
+
+// replaySession feeds the inbound messages of a recording to a fresh server for rootPath,
+// one at a time, and prints a diff for every response that differs from the recording.
+// It returns the process exit code.
+func replaySession(path, rootPath string, workspaces *workspaceRegistry) int {
+	messages, err := loadRecording(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load recording: %v\n", err)
+		return 1
+	}
+
+	absRoot, err := filepath.Abs(rootPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	oldRoot := strings.TrimSuffix(recordedRootURI(messages), "/")
+	newRoot := "file://" + filepath.ToSlash(absRoot)
+
+	// Recorded responses, keyed by request ID
+	expected := make(map[string]json.RawMessage)
+	for _, message := range messages {
+		var msg RPCRequest
+		if message.Direction == directionOut && json.Unmarshal(message.Message, &msg) == nil && msg.Method == "" && len(msg.ID) > 0 {
+			expected[requestKey(msg.ID)] = message.Message
+		}
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	server := newServer(pipeWriter, workspaces)
+	server.exit = func(int) {}
+	collector := &replayCollector{
+		responses: make(map[string]RPCRequest),
+		arrived:   make(chan struct{}, 1),
+		server:    server,
+	}
+	go collector.run(pipeReader)
+
+	replayed, differing := 0, 0
+	for _, message := range messages {
+		if message.Direction != directionIn {
+			continue
+		}
+
+		body := []byte(message.Message)
+		if oldRoot != "" {
+			body = []byte(strings.ReplaceAll(string(body), oldRoot, newRoot))
+		}
+
+		var req RPCRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Method == "" || req.Method == "exit" {
+			// Client responses are answered by the collector, exit would end the replay
+			continue
+		}
+
+		if checkInitializedOrFail(req.ID, server, req.Method) {
+			handleRequest(context.Background(), server, req)
+		}
+		// Answer every request from a complete index, as indexing time differs between runs
+		server.waitForIndexing()
+		if len(req.ID) == 0 {
+			continue
+		}
+
+		replayed++
+		want, recorded := expected[requestKey(req.ID)]
+		got, answered := collector.take(req.ID)
+		if !recorded {
+			continue
+		}
+
+		var gotBody []byte
+		if answered {
+			gotBody = replayedResponseBody(got)
+			if oldRoot != "" {
+				gotBody = []byte(strings.ReplaceAll(string(gotBody), newRoot, oldRoot))
+			}
+		}
+
+		if diff := diffResponses(req.Method, want, gotBody); diff != "" {
+			differing++
+			fmt.Printf("Response to '%s - (%s)' differs:\n%s\n", req.Method, req.ID, diff)
+		}
+	}
+
+	server.releaseWorkspaces()
+	pipeWriter.Close()
+
+	fmt.Printf("Replayed %d requests, %d differ\n", replayed, differing)
+	if differing > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replayedResponseBody encodes a collected response in the shape it was recorded in
+func replayedResponseBody(resp RPCRequest) []byte {
+	var body []byte
+	if resp.Error != nil {
+		body, _ = json.Marshal(RPCErrorResponse{Jsonrpc: "2.0", ID: resp.ID, Error: resp.Error})
+	} else {
+		body, _ = json.Marshal(RPCSuccessResponse{Jsonrpc: "2.0", ID: resp.ID, Result: resp.Result})
+	}
+	return body
+}
+
+// diffResponses compares a recorded and a replayed response and returns a line diff of
+// their indented JSON, or an empty string if they are equivalent.
+func diffResponses(method string, want, got []byte) string {
+	wantValue := normalizeResponse(method, want)
+	gotValue := normalizeResponse(method, got)
+	if reflect.DeepEqual(wantValue, gotValue) {
+		return ""
+	}
+
+	wantText, _ := json.MarshalIndent(wantValue, "", "  ")
+	gotText, _ := json.MarshalIndent(gotValue, "", "  ")
+	return diffLines(strings.Split(string(wantText), "\n"), strings.Split(string(gotText), "\n"))
+}
+
+// normalizeResponse decodes a response for comparison, dropping fields that are expected to
+// differ between runs such as the JSON-RPC envelope and the server version.
+func normalizeResponse(method string, body []byte) any {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var value map[string]any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return string(body)
+	}
+	delete(value, "jsonrpc")
+	delete(value, "id")
+
+	if method == "initialize" {
+		if result, ok := value["result"].(map[string]any); ok {
+			if info, ok := result["serverInfo"].(map[string]any); ok {
+				delete(info, "version")
+			}
+		}
+	}
+	return value
+}
+
+// diffLines returns a minimal line diff between want and got, prefixing removed lines
+// with "-" and added lines with "+".
+func diffLines(want, got []string) string {
+	// Longest common subsequence table
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			b.WriteString("  " + want[i] + "\n")
+			i++
+			j++
+		case j < len(got) && (i == len(want) || lcs[i][j+1] >= lcs[i+1][j]):
+			b.WriteString("+ " + got[j] + "\n")
+			j++
+		default:
+			b.WriteString("- " + want[i] + "\n")
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,253 @@
+// references finds the occurrences of a symbol. The text of every workspace file is searched
+// for the whole word in parallel, and definition sites and ctags reference tags (produced with
+// --extras=+r) come from the index.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// binarySniffLength is how much of a file is checked for NUL bytes to skip binary files
+const binarySniffLength = 8000
+
+// ReferenceParams represents the parameters of the 'textDocument/references' request
+type ReferenceParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      ReferenceContext       `json:"context"`
+}
+
+// ReferenceContext tells whether the declaration of the symbol should be included
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+// handleReferences processes the 'textDocument/references' request
+func handleReferences(ctx context.Context, server *Server, req RPCRequest) {
+	var params ReferenceParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	filePath := uriToPath(params.TextDocument.URI)
+	word, err := server.getCurrentWord(ctx, filePath, params.Position)
+	if err != nil {
+		server.out.sendResult(req.ID, nil) // No symbol found at position
+		return
+	}
+
+	var definitionTags, referenceTags []workspaceEntry
+	err = server.forEachNamed(ctx, word, func(workspace *Workspace, entry TagEntry) {
+		definitionTags = append(definitionTags, workspaceEntry{workspace, entry})
+	})
+	if err == nil {
+		err = server.forEachReferenceTag(ctx, word, func(workspace *Workspace, entry TagEntry) {
+			referenceTags = append(referenceTags, workspaceEntry{workspace, entry})
+		})
+	}
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	definitions := make(map[Location]struct{})
+	for _, m := range definitionTags {
+		if location, ok := server.tagLocation(m.workspace, m.entry); ok {
+			definitions[location] = struct{}{}
+		}
+	}
+	found := make(map[Location]struct{})
+	for _, m := range referenceTags {
+		if location, ok := server.tagLocation(m.workspace, m.entry); ok {
+			found[location] = struct{}{}
+		}
+	}
+
+	for _, workspace := range server.workspaceFolders() {
+		if err != nil {
+			break
+		}
+		// The text search finds uses that ctags doesn't tag
+		var locations []Location
+		locations, err = server.searchWord(ctx, workspace, word)
+		for _, location := range locations {
+			found[location] = struct{}{}
+		}
+	}
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	for location := range definitions {
+		if params.Context.IncludeDeclaration {
+			found[location] = struct{}{}
+		} else {
+			delete(found, location)
+		}
+	}
+
+	locations := make([]Location, 0, len(found))
+	for location := range found {
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return a.Range.Start.Line < b.Range.Start.Line
+		}
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+	server.out.sendResult(req.ID, locations)
+}
+
+// tagLocation returns the location of a tag's name in its file.
+func (s *Server) tagLocation(workspace *Workspace, entry TagEntry) (Location, bool) {
+	uri, err := relativePathToAbsoluteURI(workspace.rootPath, entry.Path)
+	if err != nil {
+		logWarnf("Failed to build URI for %s: %v", entry.Path, err)
+		return Location{}, false
+	}
+
+	content, err := s.cache.GetOrLoadFileContent(workspace.absolutePath(entry.Path))
+	if err != nil {
+		logWarnf("Failed to get content for file %s: %v", entry.Path, err)
+		return Location{}, false
+	}
+
+	return Location{
+		URI:   uri,
+		Range: findSymbolRangeInFile(content, entry.Name, entry.Line, s.encoding),
+	}, true
+}
+
+// searchWord finds the whole-word occurrences of word in the files of a workspace folder,
+// searching several files at once. Open documents are searched in their unsaved state.
+func (s *Server) searchWord(ctx context.Context, workspace *Workspace, word string) ([]Location, error) {
+	listed, err := listWorkspaceFiles(workspace.rootPath)
+	if err != nil {
+		logWarnf("Failed to list files of %s: %v", workspace.rootPath, err)
+	}
+
+	// New files may only exist in the editor so far
+	files := make(map[string]struct{}, len(listed))
+	for _, file := range listed {
+		files[file] = struct{}{}
+	}
+	for _, filePath := range s.openDocuments() {
+		if rel, err := toRootRelativePath(workspace.rootPath, filePath); err == nil {
+			files[rel] = struct{}{}
+		}
+	}
+
+	var mu sync.Mutex
+	var locations []Location
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				found := s.searchFile(workspace, file, word)
+				if len(found) > 0 {
+					mu.Lock()
+					locations = append(locations, found...)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for file := range files {
+		if workspace.settings.excluded(file) || isVCSPath(file) {
+			continue
+		}
+		select {
+		case jobs <- file:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return locations, ctx.Err()
+}
+
+// searchFile finds the whole-word occurrences of word in a root-relative file.
+// Binary and unreadable files have none.
+func (s *Server) searchFile(workspace *Workspace, file, word string) []Location {
+	filePath := workspace.absolutePath(file)
+
+	var content []byte
+	if lines, ok := s.bufferLines(filePath); ok {
+		content = []byte(strings.Join(lines, "\n"))
+	} else {
+		var err error
+		if content, err = os.ReadFile(filePath); err != nil {
+			return nil
+		}
+	}
+
+	if !bytes.Contains(content, []byte(word)) {
+		return nil
+	}
+	if bytes.IndexByte(content[:min(len(content), binarySniffLength)], 0) >= 0 {
+		return nil
+	}
+
+	uri, err := relativePathToAbsoluteURI(workspace.rootPath, file)
+	if err != nil {
+		return nil
+	}
+
+	var locations []Location
+	for lineIdx, line := range strings.Split(string(content), "\n") {
+		for offset := 0; ; {
+			i := strings.Index(line[offset:], word)
+			if i == -1 {
+				break
+			}
+			start, end := offset+i, offset+i+len(word)
+			offset = end
+
+			if !isWordBoundary(line, start, end) {
+				continue
+			}
+			locations = append(locations, Location{
+				URI: uri,
+				Range: Range{
+					Start: Position{Line: lineIdx, Character: byteOffsetToCharacter(line, start, s.encoding)},
+					End:   Position{Line: lineIdx, Character: byteOffsetToCharacter(line, end, s.encoding)},
+				},
+			})
+		}
+	}
+	return locations
+}
+
+// isWordBoundary reports whether line[start:end] is neither preceded nor followed by an
+// identifier character.
+func isWordBoundary(line string, start, end int) bool {
+	if r, _ := utf8.DecodeLastRuneInString(line[:start]); start > 0 && isIdentifierChar(r) {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(line[end:]); end < len(line) && isIdentifierChar(r) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+// requests tracks in-flight JSON-RPC requests and their contexts so that
+// '$/cancelRequest' notifications can stop long-running handlers.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// CancelParams represents the parameters for the '$/cancelRequest' notification
+type CancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// requestTracker maps request IDs to the cancel functions of their contexts
+type requestTracker struct {
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// requestKey normalizes a raw JSON-RPC ID so numeric and string IDs stay distinct.
+func requestKey(id json.RawMessage) string {
+	return strings.TrimSpace(string(id))
+}
+
+// begin registers a request and returns its context along with a function that
+// must be called once the request has been answered.
+func (rt *requestTracker) begin(parent context.Context, id json.RawMessage) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	key := requestKey(id)
+
+	rt.mu.Lock()
+	if rt.running == nil {
+		rt.running = make(map[string]context.CancelFunc)
+	}
+	rt.running[key] = cancel
+	rt.mu.Unlock()
+
+	return ctx, func() {
+		rt.mu.Lock()
+		delete(rt.running, key)
+		rt.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the context of a running request. It reports whether the request was found.
+func (rt *requestTracker) cancel(id json.RawMessage) bool {
+	rt.mu.Lock()
+	cancel, ok := rt.running[requestKey(id)]
+	rt.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// isCancelled reports whether the request context has been cancelled without blocking.
+func isCancelled(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,204 @@
+// settings contains the ctags settings a client can pass through initializationOptions
+// and 'workspace/didChangeConfiguration', and how they are merged with the command line
+// and the project configuration file.
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// settingsSection is the configuration section clients use for this server
+const settingsSection = "ctags-lsp"
+
+// Settings holds the options that control how a workspace is indexed.
+// Empty values keep the value of the layer below.
+type Settings struct {
+	CtagsBin  string   `json:"ctagsBin,omitempty"`
+	Tagfile   string   `json:"tagfile,omitempty"`
+	Languages string   `json:"languages,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+	// ExcludeKinds drops tags of these kinds, such as "variable"
+	ExcludeKinds []string `json:"excludeKinds,omitempty"`
+}
+
+// DidChangeConfigurationParams represents the 'workspace/didChangeConfiguration' notification
+type DidChangeConfigurationParams struct {
+	Settings json.RawMessage `json:"settings"`
+}
+
+// settings returns the command-line options as the base settings layer
+func (c *Config) settings() Settings {
+	return Settings{
+		CtagsBin:  c.ctagsBin,
+		Tagfile:   c.tagfilePath,
+		Languages: c.languages,
+	}
+}
+
+// settingsKeys are the JSON keys of Settings
+var settingsKeys = []string{"ctagsBin", "tagfile", "languages", "exclude", "extraArgs", "excludeKinds"}
+
+// parseSettings decodes client settings, either as a bare object or nested under the
+// "ctags-lsp" section. It reports false for null or missing settings, which pull-model
+// clients send to announce that the configuration changed without including it, and for
+// objects that hold neither the section nor any settings key, such as the settings of
+// other language servers.
+func parseSettings(raw json.RawMessage) (Settings, bool, error) {
+	var settings Settings
+	if isNullSettings(raw) {
+		return settings, false, nil
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		return settings, true, err
+	}
+	if section, ok := sections[settingsSection]; ok {
+		if isNullSettings(section) {
+			return settings, false, nil
+		}
+		raw = section
+	} else if !hasSettingsKey(sections) {
+		return settings, false, nil
+	}
+
+	err := json.Unmarshal(raw, &settings)
+	return settings, true, err
+}
+
+// hasSettingsKey reports whether a settings object contains any key of Settings
+func hasSettingsKey(sections map[string]json.RawMessage) bool {
+	for _, key := range settingsKeys {
+		if _, ok := sections[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isNullSettings reports whether raw settings are missing or null
+func isNullSettings(raw json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(raw))
+	return trimmed == "" || trimmed == "null"
+}
+
+// merge returns s with every non-empty value of override applied on top.
+func (s Settings) merge(override Settings) Settings {
+	if override.CtagsBin != "" {
+		s.CtagsBin = override.CtagsBin
+	}
+	if override.Tagfile != "" {
+		s.Tagfile = override.Tagfile
+	}
+	if override.Languages != "" {
+		s.Languages = override.Languages
+	}
+	if override.Exclude != nil {
+		s.Exclude = override.Exclude
+	}
+	if override.ExtraArgs != nil {
+		s.ExtraArgs = override.ExtraArgs
+	}
+	if override.ExcludeKinds != nil {
+		s.ExcludeKinds = override.ExcludeKinds
+	}
+	return s
+}
+
+// key returns a string that is equal for equal settings, used to share indexes
+func (s Settings) key() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// excluded reports whether a root-relative path matches one of the exclude patterns.
+// Like ctags, a pattern matches either the whole path or any single path element.
+func (s Settings) excluded(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range s.Exclude {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+		for _, element := range strings.Split(rel, "/") {
+			if matched, _ := path.Match(pattern, element); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludedKind reports whether tags of a kind are filtered out.
+func (s Settings) excludedKind(kind string) bool {
+	for _, excluded := range s.ExcludeKinds {
+		if kind == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// settingsFor returns the effective settings for a workspace folder of the connection.
+// Later layers win: command line, client settings, then the project configuration file.
+func (s *Server) settingsFor(rootPath string) Settings {
+	project, err := loadProjectSettings(rootPath)
+	if err != nil {
+		logWarnf("Ignoring project configuration: %v", err)
+	}
+
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	return s.workspaces.config.settings().merge(s.clientSettings).merge(project)
+}
+
+// applySettings stores new client settings and re-indexes the workspace folders they affect.
+func (s *Server) applySettings(settings Settings) {
+	s.foldersMu.Lock()
+	s.clientSettings = settings
+	s.foldersMu.Unlock()
+
+	s.reindexChangedFolders()
+}
+
+// reindexChangedFolders re-indexes every workspace folder whose effective settings changed.
+// The old index keeps answering requests until the new one is ready.
+func (s *Server) reindexChangedFolders() {
+	for _, old := range s.workspaceFolders() {
+		settings := s.settingsFor(old.rootPath)
+		if settings.key() == old.settings.key() {
+			continue
+		}
+
+		logInfof("Settings changed, re-indexing %s", old.rootPath)
+		workspace := s.workspaces.acquire(old.rootPath, settings)
+		go s.reportIndexing(workspace)
+		<-workspace.indexed
+		if workspace.indexErr != nil {
+			s.workspaces.release(workspace)
+			continue
+		}
+
+		s.foldersMu.Lock()
+		replaced := false
+		for i, folder := range s.folders {
+			if folder == old {
+				s.folders[i] = workspace
+				replaced = true
+			}
+		}
+		s.foldersMu.Unlock()
+
+		// The folder may have been removed while scanning
+		if replaced {
+			s.workspaces.release(old)
+			s.retagOpenDocuments(workspace.rootPath)
+		} else {
+			s.workspaces.release(workspace)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Settings
+		wantOK  bool
+		wantErr bool
+	}{
+		{"missing", "", Settings{}, false, false},
+		{"null", "null", Settings{}, false, false},
+		{"bare", `{"languages": "Go"}`, Settings{Languages: "Go"}, true, false},
+		{"section", `{"ctags-lsp": {"exclude": ["vendor"]}}`, Settings{Exclude: []string{"vendor"}}, true, false},
+		{"null section", `{"ctags-lsp": null}`, Settings{}, false, false},
+		{"empty section", `{"ctags-lsp": {}}`, Settings{}, true, false},
+		{"other servers", `{"settings": {"python": {"analysis": {}}}}`, Settings{}, false, false},
+		{"other server next to section", `{"python": {}, "ctags-lsp": {"languages": "Go"}}`, Settings{Languages: "Go"}, true, false},
+		{"empty value", `{"languages": ""}`, Settings{}, true, false},
+		{"invalid", `[1]`, Settings{}, true, true},
+		{"wrong type", `{"exclude": "vendor"}`, Settings{}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseSettings([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// signature_help shows the signatures ctags recorded for the function being called at the
+// cursor. The call is found by scanning back to the unclosed parenthesis, and the active
+// parameter is the number of commas between it and the cursor.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// signatureScanLines bounds how many lines above the cursor are searched for the call
+const signatureScanLines = 50
+
+// SignatureHelpOptions defines options for the signature help provider
+type SignatureHelpOptions struct {
+	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
+}
+
+// SignatureHelp represents the result of the 'textDocument/signatureHelp' request
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature int                    `json:"activeSignature"`
+	ActiveParameter int                    `json:"activeParameter"`
+}
+
+// SignatureInformation describes one signature of a callable
+type SignatureInformation struct {
+	Label         string                 `json:"label"`
+	Documentation *MarkupContent         `json:"documentation,omitempty"`
+	Parameters    []ParameterInformation `json:"parameters"`
+}
+
+// ParameterInformation locates a parameter within its signature label
+type ParameterInformation struct {
+	Label [2]int `json:"label"` // start and end offset in the label
+}
+
+// handleSignatureHelp processes the 'textDocument/signatureHelp' request
+func handleSignatureHelp(ctx context.Context, server *Server, req RPCRequest) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	filePath := uriToPath(params.TextDocument.URI)
+	lines, ok := server.openDocumentLines(ctx, filePath)
+	if !ok {
+		var err error
+		if lines, err = server.cache.GetOrLoadFileContent(filePath); err != nil {
+			server.out.sendResult(req.ID, nil)
+			return
+		}
+	}
+	if params.Position.Line >= len(lines) {
+		server.out.sendResult(req.ID, nil)
+		return
+	}
+	line := lines[params.Position.Line]
+	offset, _ := characterToByteOffset(line, params.Position.Character, server.encoding)
+
+	first := max(0, params.Position.Line-signatureScanLines)
+	before := strings.Join(append(append([]string(nil), lines[first:params.Position.Line]...), line[:offset]), "\n")
+	callee, commas, ok := enclosingCall(before)
+	if !ok {
+		server.out.sendResult(req.ID, nil)
+		return
+	}
+
+	var matches []workspaceEntry
+	err := server.forEachNamed(ctx, callee, func(workspace *Workspace, entry TagEntry) {
+		if entry.Signature != "" {
+			matches = append(matches, workspaceEntry{workspace, entry})
+		}
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	help := SignatureHelp{ActiveSignature: -1, ActiveParameter: commas}
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		label := m.entry.Name + m.entry.Signature
+		if seen[label] {
+			continue // Declarations and definitions often share a signature
+		}
+		seen[label] = true
+
+		signature := SignatureInformation{
+			Label:      label,
+			Parameters: signatureParameters(label, len(m.entry.Name), server.encoding),
+		}
+		if doc := server.entryDoc(m.workspace, m.entry); doc != "" {
+			signature.Documentation = &MarkupContent{Kind: "markdown", Value: doc}
+		}
+		if help.ActiveSignature < 0 && len(signature.Parameters) > commas {
+			help.ActiveSignature = len(help.Signatures)
+		}
+		help.Signatures = append(help.Signatures, signature)
+	}
+
+	if len(help.Signatures) == 0 {
+		server.out.sendResult(req.ID, nil)
+		return
+	}
+	help.ActiveSignature = max(help.ActiveSignature, 0)
+	server.out.sendResult(req.ID, help)
+}
+
+// enclosingCall finds the call whose arguments text ends in. It returns the name before
+// the unclosed parenthesis and the number of commas after it, and reports false if text
+// doesn't end inside a call. Brackets in strings and comments are not told apart.
+func enclosingCall(text string) (string, int, bool) {
+	depth, commas := 0, 0
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case ')', ']', '}':
+			depth++
+		case '[', '{':
+			if depth == 0 {
+				return "", 0, false // Inside a list or block, not a call
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				commas++
+			}
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+
+			end := len(strings.TrimRight(text[:i], " \t"))
+			start := end
+			for start > 0 {
+				r, size := utf8.DecodeLastRuneInString(text[:start])
+				if !isIdentifierChar(r) {
+					break
+				}
+				start -= size
+			}
+			if start == end {
+				return "", 0, false
+			}
+			return text[start:end], commas, true
+		}
+	}
+	return "", 0, false
+}
+
+// signatureParameters splits the parameter list that starts at byte offset open of label
+// into parameters, located by offsets in the position encoding.
+func signatureParameters(label string, open int, encoding string) []ParameterInformation {
+	if open >= len(label) || label[open] != '(' {
+		return []ParameterInformation{}
+	}
+
+	parameters := []ParameterInformation{}
+	add := func(start, end int) {
+		// Trim the whitespace around the parameter
+		for start < end && (label[start] == ' ' || label[start] == '\t') {
+			start++
+		}
+		for end > start && (label[end-1] == ' ' || label[end-1] == '\t') {
+			end--
+		}
+		if start < end {
+			parameters = append(parameters, ParameterInformation{Label: [2]int{
+				byteOffsetToCharacter(label, start, encoding),
+				byteOffsetToCharacter(label, end, encoding),
+			}})
+		}
+	}
+
+	// Angle brackets only nest as template or generic arguments like map<K, V>, not in
+	// operators like -> and => or comparisons in default values
+	depth, angles := 0, 0
+	start := open + 1
+	for i := start; i < len(label); i++ {
+		switch label[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				add(start, i) // The closing parenthesis of the list
+				return parameters
+			}
+			depth--
+		case '<':
+			if r, _ := utf8.DecodeLastRuneInString(label[:i]); isIdentifierChar(r) {
+				angles++
+			}
+		case '>':
+			if angles > 0 && label[i-1] != '-' && label[i-1] != '=' {
+				angles--
+			}
+		case ',':
+			if depth == 0 && angles == 0 {
+				add(start, i)
+				start = i + 1
+			}
+		}
+	}
+	add(start, len(label))
+	return parameters
+}
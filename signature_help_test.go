@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSignatureParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string
+		open  int
+		want  []string
+	}{
+		{"empty", "f()", 1, []string{}},
+		{"simple", "f(a, b)", 1, []string{"a", "b"}},
+		{"nested call", "f(a = g(1, 2), b)", 1, []string{"a = g(1, 2)", "b"}},
+		{"template", "f(std::map<int, int> m, int x)", 1, []string{"std::map<int, int> m", "int x"}},
+		{"arrow in default", "f(int x = p->y, int z)", 1, []string{"int x = p->y", "int z"}},
+		{"fat arrow in default", "f($a = ['k' => 1], $b)", 1, []string{"$a = ['k' => 1]", "$b"}},
+		{"comparison in default", "f(bool x = a < b, int y)", 1, []string{"bool x = a < b", "int y"}},
+		{"unterminated", "f(a, b", 1, []string{"a", "b"}},
+		{"no parameter list", "f", 1, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, parameter := range signatureParameters(tt.label, tt.open, positionEncodingUTF8) {
+				got = append(got, tt.label[parameter.Label[0]:parameter.Label[1]])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("signatureParameters(%q) = %q, want %q", tt.label, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignatureParametersUTF16(t *testing.T) {
+	// "é" is one UTF-16 code unit but two bytes
+	got := signatureParameters("f(é, x)", 1, positionEncodingUTF16)
+	want := []ParameterInformation{{Label: [2]int{2, 3}}, {Label: [2]int{5, 6}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEnclosingCall(t *testing.T) {
+	tests := []struct {
+		text   string
+		callee string
+		commas int
+		ok     bool
+	}{
+		{"foo(", "foo", 0, true},
+		{"foo(a, b", "foo", 1, true},
+		{"foo(bar(1, 2), ", "foo", 1, true},
+		{"foo (a", "foo", 0, true},
+		{"foo(a)", "", 0, false},
+		{"foo([1, 2", "", 0, false},
+		{"(a, b", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		callee, commas, ok := enclosingCall(tt.text)
+		if callee != tt.callee || commas != tt.commas || ok != tt.ok {
+			t.Errorf("enclosingCall(%q) = %q, %d, %v, want %q, %d, %v", tt.text, callee, commas, ok, tt.callee, tt.commas, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// symbol_store indexes the tag entries of a workspace by file, by exact name and by
+// lowercase name prefix, so lookups don't scan every tag and a single file's entries can
+// be replaced in time proportional to that file.
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// symbolStore holds tag entries with the indexes needed by the request handlers.
+// Lookups only read it and may run concurrently; Workspace guards changes with its mutex.
+type symbolStore struct {
+	byFile map[string][]TagEntry            // path -> entries in ctags order
+	byName map[string]map[string][]TagEntry // name -> path -> entries
+	prefix trieNode                         // lowercase names
+}
+
+// trieNode is a node of a byte trie over lowercase symbol names
+type trieNode struct {
+	label    byte
+	children []*trieNode         // sorted by label
+	names    map[string]struct{} // names whose lowercase form ends here
+}
+
+// newSymbolStore creates an empty symbol store
+func newSymbolStore() symbolStore {
+	return symbolStore{
+		byFile: make(map[string][]TagEntry),
+		byName: make(map[string]map[string][]TagEntry),
+	}
+}
+
+// add inserts entries into the store.
+func (st *symbolStore) add(entries []TagEntry) {
+	for _, entry := range entries {
+		st.byFile[entry.Path] = append(st.byFile[entry.Path], entry)
+
+		paths, ok := st.byName[entry.Name]
+		if !ok {
+			paths = make(map[string][]TagEntry)
+			st.byName[entry.Name] = paths
+			st.prefix.insert(entry.Name)
+		}
+		paths[entry.Path] = append(paths[entry.Path], entry)
+	}
+}
+
+// removeFile drops every entry of a file.
+func (st *symbolStore) removeFile(path string) {
+	for _, entry := range st.byFile[path] {
+		paths, ok := st.byName[entry.Name]
+		if !ok {
+			continue
+		}
+		if _, ok := paths[path]; ok {
+			delete(paths, path)
+			if len(paths) == 0 {
+				delete(st.byName, entry.Name)
+				st.prefix.remove(entry.Name)
+			}
+		}
+	}
+	delete(st.byFile, path)
+}
+
+// replaceFile swaps the entries of a file for new ones.
+func (st *symbolStore) replaceFile(path string, entries []TagEntry) {
+	st.removeFile(path)
+	st.add(entries)
+}
+
+// each visits every entry, file by file in path order, until visit returns false.
+func (st *symbolStore) each(visit func(TagEntry) bool) {
+	for _, path := range sortedKeys(st.byFile) {
+		for _, entry := range st.byFile[path] {
+			if !visit(entry) {
+				return
+			}
+		}
+	}
+}
+
+// inFile visits the entries of a file until visit returns false.
+func (st *symbolStore) inFile(path string, visit func(TagEntry) bool) {
+	for _, entry := range st.byFile[path] {
+		if !visit(entry) {
+			return
+		}
+	}
+}
+
+// named visits the entries with an exact name, in path order, until visit returns false.
+// It reports whether all entries were visited.
+func (st *symbolStore) named(name string, visit func(TagEntry) bool) bool {
+	paths := st.byName[name]
+	for _, path := range sortedKeys(paths) {
+		for _, entry := range paths[path] {
+			if !visit(entry) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// withPrefix visits the entries whose name starts with prefix, ignoring case, in name
+// order until visit returns false.
+func (st *symbolStore) withPrefix(prefix string, visit func(TagEntry) bool) {
+	node := st.prefix.find(strings.ToLower(prefix))
+	if node == nil {
+		return
+	}
+	node.walk(func(name string) bool {
+		return st.named(name, visit)
+	})
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// child returns the child node for a label, creating it if create is set.
+func (n *trieNode) child(label byte, create bool) *trieNode {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].label >= label })
+	if i < len(n.children) && n.children[i].label == label {
+		return n.children[i]
+	}
+	if !create {
+		return nil
+	}
+	node := &trieNode{label: label}
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = node
+	return node
+}
+
+// insert adds a name under its lowercase form.
+func (n *trieNode) insert(name string) {
+	node := n
+	for _, b := range []byte(strings.ToLower(name)) {
+		node = node.child(b, true)
+	}
+	if node.names == nil {
+		node.names = make(map[string]struct{})
+	}
+	node.names[name] = struct{}{}
+}
+
+// remove deletes a name and prunes nodes that no longer lead to any name.
+func (n *trieNode) remove(name string) {
+	key := []byte(strings.ToLower(name))
+	path := make([]*trieNode, 0, len(key)+1)
+	node := n
+	path = append(path, node)
+	for _, b := range key {
+		node = node.child(b, false)
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	delete(node.names, name)
+
+	for i := len(path) - 1; i > 0; i-- {
+		node := path[i]
+		if len(node.names) > 0 || len(node.children) > 0 {
+			break
+		}
+		parent := path[i-1]
+		j := sort.Search(len(parent.children), func(j int) bool { return parent.children[j].label >= node.label })
+		parent.children = append(parent.children[:j], parent.children[j+1:]...)
+	}
+}
+
+// find returns the node for a lowercase prefix, or nil if no name starts with it.
+func (n *trieNode) find(prefix string) *trieNode {
+	node := n
+	for i := 0; i < len(prefix) && node != nil; i++ {
+		node = node.child(prefix[i], false)
+	}
+	return node
+}
+
+// walk visits the names below a node in lexical order until visit returns false.
+func (n *trieNode) walk(visit func(name string) bool) bool {
+	names := make([]string, 0, len(n.names))
+	for name := range n.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !visit(name) {
+			return false
+		}
+	}
+	for _, child := range n.children {
+		if !child.walk(visit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// namesWithPrefix returns the names withPrefix visits, one per entry
+func namesWithPrefix(st *symbolStore, prefix string) []string {
+	var names []string
+	st.withPrefix(prefix, func(entry TagEntry) bool {
+		names = append(names, entry.Name)
+		return true
+	})
+	return names
+}
+
+func TestTrieInsertAndRemove(t *testing.T) {
+	var root trieNode
+	root.insert("abc")
+	root.insert("abd")
+	root.insert("b")
+
+	if len(root.children) != 2 || root.children[0].label != 'a' || root.children[1].label != 'b' {
+		t.Fatalf("root children are not sorted by label: %+v", root.children)
+	}
+	ab := root.find("ab")
+	if ab == nil || len(ab.children) != 2 {
+		t.Fatalf("find(ab) = %+v, want a node with two children", ab)
+	}
+
+	// Removing one name keeps the prefix it shares with another
+	root.remove("abd")
+	if root.find("abd") != nil {
+		t.Error("abd was not pruned")
+	}
+	if node := root.find("abc"); node == nil || len(node.names) != 1 {
+		t.Errorf("find(abc) = %+v, want the node of abc", node)
+	}
+
+	// Removing the last name below a prefix prunes the whole branch
+	root.remove("abc")
+	if root.find("a") != nil {
+		t.Error("the branch of a was not pruned")
+	}
+	if len(root.children) != 1 || root.children[0].label != 'b' {
+		t.Errorf("root children = %+v, want only b", root.children)
+	}
+
+	// Removing a missing name is a no-op
+	root.remove("xyz")
+	root.remove("bc")
+	if node := root.find("b"); node == nil || len(node.names) != 1 {
+		t.Errorf("find(b) = %+v, want the node of b", node)
+	}
+}
+
+func TestTrieCaseFoldedNames(t *testing.T) {
+	var root trieNode
+	root.insert("Foo")
+	root.insert("foo")
+	root.insert("FOO")
+
+	node := root.find("foo")
+	if node == nil || len(node.names) != 3 {
+		t.Fatalf("find(foo) = %+v, want one node holding all three names", node)
+	}
+
+	var names []string
+	root.walk(func(name string) bool {
+		names = append(names, name)
+		return true
+	})
+	if want := []string{"FOO", "Foo", "foo"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("walk = %q, want %q", names, want)
+	}
+
+	// The node stays while any spelling of the name is left
+	root.remove("Foo")
+	root.remove("FOO")
+	if node := root.find("foo"); node == nil || len(node.names) != 1 {
+		t.Errorf("find(foo) = %+v, want the node of foo", node)
+	}
+	root.remove("foo")
+	if len(root.children) != 0 {
+		t.Errorf("root children = %+v, want none", root.children)
+	}
+}
+
+func TestSymbolStoreWithPrefix(t *testing.T) {
+	st := newSymbolStore()
+	st.add([]TagEntry{
+		{Name: "parse", Path: "b.go"},
+		{Name: "Parser", Path: "a.go"},
+		{Name: "print", Path: "a.go"},
+		{Name: "parse", Path: "a.go"},
+	})
+
+	if got, want := namesWithPrefix(&st, "PAR"), []string{"parse", "parse", "Parser"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withPrefix(PAR) = %q, want %q", got, want)
+	}
+	if got, want := namesWithPrefix(&st, "p"), []string{"parse", "parse", "Parser", "print"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withPrefix(p) = %q, want %q", got, want)
+	}
+	if got := namesWithPrefix(&st, "q"); got != nil {
+		t.Errorf("withPrefix(q) = %q, want nothing", got)
+	}
+
+	// Entries of the same name are visited in path order
+	var paths []string
+	st.named("parse", func(entry TagEntry) bool {
+		paths = append(paths, entry.Path)
+		return true
+	})
+	if want := []string{"a.go", "b.go"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("named(parse) paths = %q, want %q", paths, want)
+	}
+}
+
+func TestSymbolStoreReplaceFile(t *testing.T) {
+	st := newSymbolStore()
+	st.add([]TagEntry{
+		{Name: "Shared", Path: "a.go"},
+		{Name: "OnlyA", Path: "a.go"},
+		{Name: "Shared", Path: "b.go"},
+	})
+
+	st.replaceFile("a.go", []TagEntry{{Name: "NewA", Path: "a.go"}})
+
+	if _, ok := st.byName["OnlyA"]; ok {
+		t.Error("OnlyA is still in byName")
+	}
+	if got := namesWithPrefix(&st, "only"); got != nil {
+		t.Errorf("withPrefix(only) = %q, want nothing", got)
+	}
+	if paths := st.byName["Shared"]; len(paths) != 1 || paths["b.go"] == nil {
+		t.Errorf("byName[Shared] = %+v, want only b.go", paths)
+	}
+	if got, want := namesWithPrefix(&st, "new"), []string{"NewA"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withPrefix(new) = %q, want %q", got, want)
+	}
+	if got := sortedKeys(st.byFile); !reflect.DeepEqual(got, []string{"a.go", "b.go"}) {
+		t.Errorf("byFile keys = %q", got)
+	}
+
+	// Removing the last file of a name drops it from every index
+	st.removeFile("b.go")
+	if _, ok := st.byName["Shared"]; ok {
+		t.Error("Shared is still in byName")
+	}
+	if st.prefix.find("s") != nil {
+		t.Error("the trie still holds Shared")
+	}
+	if got := sortedKeys(st.byFile); !reflect.DeepEqual(got, []string{"a.go"}) {
+		t.Errorf("byFile keys = %q, want only a.go", got)
+	}
+
+	// Replacing a file with nothing removes it
+	st.replaceFile("a.go", nil)
+	if len(st.byFile) != 0 || len(st.byName) != 0 || len(st.prefix.children) != 0 {
+		t.Errorf("store is not empty: %+v", st)
+	}
+}
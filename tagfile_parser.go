@@ -4,7 +4,6 @@ package main
 
 import (
 	"bufio"
-	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -149,6 +148,7 @@ func parseTagfileEntry(line, tagsPath, rootPath string, kindMap *tagfileKindMap)
 		next = 4
 	}
 
+	fileScope := false
 	for _, field := range fields[next:] {
 		if field == "" {
 			continue
@@ -157,6 +157,11 @@ func parseTagfileEntry(line, tagsPath, rootPath string, kindMap *tagfileKindMap)
 		if !ok {
 			continue
 		}
+		if key == "file" && value == "" {
+			// File-scoped tags are marked with an empty "file:" field
+			fileScope = true
+			continue
+		}
 
 		switch key {
 		case "line":
@@ -173,6 +178,22 @@ func parseTagfileEntry(line, tagsPath, rootPath string, kindMap *tagfileKindMap)
 			entry.Scope = value
 		case "scopeKind":
 			entry.ScopeKind = value
+		case "end":
+			if endNum, err := strconv.Atoi(value); err == nil {
+				entry.End = endNum
+			}
+		case "signature":
+			entry.Signature = value
+		case "access":
+			entry.Access = value
+		case "implementation":
+			entry.Implementation = value
+		case "inherits":
+			entry.Inherits = tagString(value)
+		case "roles":
+			entry.Roles = value
+		case "extras":
+			entry.Extras = value
 		default:
 			if entry.Scope == "" && entry.ScopeKind == "" && kindMap.isKindName(key) {
 				entry.ScopeKind = key
@@ -181,6 +202,10 @@ func parseTagfileEntry(line, tagsPath, rootPath string, kindMap *tagfileKindMap)
 		}
 	}
 
+	if fileScope && !strings.Contains(","+entry.Extras+",", ",fileScope,") {
+		entry.Extras = strings.TrimPrefix(entry.Extras+",fileScope", ",")
+	}
+
 	if entry.Line == 0 {
 		if lineNum, err := strconv.Atoi(entry.Pattern); err == nil {
 			entry.Line = lineNum
@@ -194,7 +219,7 @@ func parseTagfileEntry(line, tagsPath, rootPath string, kindMap *tagfileKindMap)
 
 	relPath, err := tagfilePathToRootRelative(rootPath, tagsPath, entry.Path)
 	if err != nil {
-		log.Printf("Failed to make path relative for %s: %v", entry.Path, err)
+		logWarnf("Failed to make path relative for %s: %v", entry.Path, err)
 		return TagEntry{}, false
 	}
 	entry.Path = relPath
@@ -0,0 +1,125 @@
+// transport connects servers to their clients: the message loop shared by all transports,
+// and the socket listener used by --listen to serve TCP or unix socket connections.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// serve reads messages from r and dispatches them until the stream is closed.
+func (s *Server) serve(r io.Reader) {
+	dispatcher := newDispatcher(s)
+	reader := NewMessageReader(r)
+	// Inbound messages go to the same recording as outbound ones
+	reader.recorder = s.out.recorder
+	for {
+		req, err := reader.Read()
+		if err != nil {
+			var parseErr *ParseError
+			var frameErr *FrameError
+			switch {
+			case errors.As(err, &parseErr):
+				s.out.sendError(nil, -32700, "Parse error", err.Error())
+				continue
+			case errors.As(err, &frameErr):
+				logWarnf("Skipping malformed message: %v", err)
+				continue
+			case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+				logInfof("Input closed")
+			default:
+				logErrorf("Error reading input: %v", err)
+			}
+			// The client went away, there is nobody left to serve
+			return
+		}
+
+		// Responses to server-to-client requests are handed to the waiting caller
+		if req.Method == "" && len(req.ID) > 0 {
+			if !s.out.deliverResponse(req) {
+				logWarnf("Received response for unknown request %s", req.ID)
+			}
+			continue
+		}
+
+		// Keep document updates ordered, run everything else concurrently
+		dispatcher.dispatch(req)
+	}
+}
+
+// parseListenAddress splits a --listen value such as "tcp:127.0.0.1:9257" or
+// "unix:/tmp/ctags-lsp.sock" into a network and an address.
+func parseListenAddress(value string) (string, string, error) {
+	network, address, ok := strings.Cut(value, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("invalid listen address %q, expected tcp:HOST:PORT or unix:PATH", value)
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("unsupported network %q in listen address %q", network, value)
+	}
+}
+
+// listen accepts client connections and serves each one with its own server.
+// Connections to the same workspace root share the index through workspaces.
+func listen(config *Config, workspaces *workspaceRegistry) error {
+	network, address, err := parseListenAddress(config.listen)
+	if err != nil {
+		return err
+	}
+
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	logInfof("Listening on %s:%s", network, listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConnection(conn, workspaces)
+	}
+}
+
+// serveConnection runs the message loop for a single client connection.
+func serveConnection(conn net.Conn, workspaces *workspaceRegistry) {
+	defer conn.Close()
+	logInfof("Client connected from %s", conn.RemoteAddr())
+
+	server := newServer(conn, workspaces)
+	// exit only ends this connection, the listener keeps running
+	server.exit = func(int) {
+		conn.Close()
+	}
+
+	server.serve(conn)
+	server.releaseWorkspaces()
+	logInfof("Client %s disconnected", conn.RemoteAddr())
+}
+
+// removeStaleSocket deletes a leftover unix socket from a previous run.
+// Regular files are left alone so a typo can't delete user data.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != fs.ModeSocket {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		logWarnf("Failed to remove stale socket %s: %v", path, err)
+	}
+}
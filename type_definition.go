@@ -0,0 +1,113 @@
+// type_definition resolves the type of the symbol under the cursor through the typeref
+// field ctags records for variables, fields and functions, and finds that type in the index.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// handleTypeDefinition processes the 'textDocument/typeDefinition' request
+func handleTypeDefinition(ctx context.Context, server *Server, req RPCRequest) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	filePath := uriToPath(params.TextDocument.URI)
+	symbol, err := server.getCurrentWord(ctx, filePath, params.Position)
+	if err != nil {
+		server.out.sendResult(req.ID, nil) // No symbol found at position
+		return
+	}
+
+	// The kinds of type each name may be, empty if the typeref doesn't say
+	types := make(map[string]map[string]bool)
+	err = server.forEachNamed(ctx, symbol, func(_ *Workspace, entry TagEntry) {
+		kind, name, ok := typeRefTarget(entry.TypeRef)
+		if !ok {
+			return
+		}
+		if types[name] == nil {
+			types[name] = make(map[string]bool)
+		}
+		types[name][kind] = true
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	var locations []Location
+	for _, name := range sortedKeys(types) {
+		var exact, other []workspaceEntry
+		err = server.forEachNamed(ctx, name, func(workspace *Workspace, entry TagEntry) {
+			if types[name][entry.Kind] {
+				exact = append(exact, workspaceEntry{workspace, entry})
+			} else if isTypeKind(entry.Kind) {
+				other = append(other, workspaceEntry{workspace, entry})
+			}
+		})
+		if err != nil {
+			server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+			return
+		}
+
+		// Prefer the kind the typeref names, such as the struct of "struct:Foo"
+		if len(exact) == 0 {
+			exact = other
+		}
+		for _, m := range exact {
+			if location, ok := server.tagLocation(m.workspace, m.entry); ok {
+				locations = append(locations, location)
+			}
+		}
+	}
+
+	if len(locations) == 0 {
+		server.out.sendResult(req.ID, nil)
+	} else if len(locations) == 1 {
+		server.out.sendResult(req.ID, locations[0])
+	} else {
+		server.out.sendResult(req.ID, locations)
+	}
+}
+
+// typeRefTarget splits a typeref such as "struct:Foo" or "typename:const Foo *" into the
+// kind of type, empty for "typename", and the name of the type. Qualifiers, pointers,
+// namespaces and type arguments are dropped, so "typename:std::vector<int>" names vector.
+func typeRefTarget(typeRef string) (string, string, bool) {
+	kind, typeName, ok := strings.Cut(typeRef, ":")
+	if !ok {
+		return "", "", false
+	}
+	if kind == "typename" {
+		kind = ""
+	}
+	typeName, _, _ = strings.Cut(typeName, "<")
+
+	// The type is the last identifier, after qualifiers like const and namespaces
+	end := len(typeName)
+	for end > 0 {
+		r, size := utf8.DecodeLastRuneInString(typeName[:end])
+		if isIdentifierChar(r) {
+			break
+		}
+		end -= size
+	}
+	start := end
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(typeName[:start])
+		if !isIdentifierChar(r) {
+			break
+		}
+		start -= size
+	}
+	if start == end {
+		return "", "", false
+	}
+	return kind, typeName[start:end], true
+}
@@ -0,0 +1,202 @@
+// type_hierarchy links types through the base classes ctags records in the inherits field.
+// Supertypes are looked up by the names a type inherits from, subtypes by scanning for
+// types whose inherits list names it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// TypeHierarchyItem represents a type in the type hierarchy
+type TypeHierarchyItem struct {
+	Name           string `json:"name"`
+	Kind           int    `json:"kind"`
+	Detail         string `json:"detail,omitempty"`
+	URI            string `json:"uri"`
+	Range          Range  `json:"range"`
+	SelectionRange Range  `json:"selectionRange"`
+}
+
+// TypeHierarchyParams represents the parameters of 'typeHierarchy/supertypes' and 'typeHierarchy/subtypes'
+type TypeHierarchyParams struct {
+	Item TypeHierarchyItem `json:"item"`
+}
+
+// handlePrepareTypeHierarchy processes the 'textDocument/prepareTypeHierarchy' request
+func handlePrepareTypeHierarchy(ctx context.Context, server *Server, req RPCRequest) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	filePath := uriToPath(params.TextDocument.URI)
+	symbol, err := server.getCurrentWord(ctx, filePath, params.Position)
+	if err != nil {
+		server.out.sendResult(req.ID, nil) // No symbol found at position
+		return
+	}
+
+	var types []workspaceEntry
+	err = server.forEachNamed(ctx, symbol, func(workspace *Workspace, entry TagEntry) {
+		if isHierarchyType(entry) {
+			types = append(types, workspaceEntry{workspace, entry})
+		}
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	items := server.typeHierarchyItems(types)
+	if len(items) == 0 {
+		server.out.sendResult(req.ID, nil)
+		return
+	}
+	server.out.sendResult(req.ID, items)
+}
+
+// handleTypeHierarchySupertypes processes the 'typeHierarchy/supertypes' request
+func handleTypeHierarchySupertypes(ctx context.Context, server *Server, req RPCRequest) {
+	var params TypeHierarchyParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	types, err := server.hierarchyEntries(ctx, params.Item)
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	bases := make(map[string]bool)
+	for _, t := range types {
+		for _, name := range inheritedNames(string(t.entry.Inherits)) {
+			bases[name] = true
+		}
+	}
+
+	var supertypes []workspaceEntry
+	for _, name := range sortedKeys(bases) {
+		err := server.forEachNamed(ctx, name, func(workspace *Workspace, entry TagEntry) {
+			if isHierarchyType(entry) {
+				supertypes = append(supertypes, workspaceEntry{workspace, entry})
+			}
+		})
+		if err != nil {
+			server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+			return
+		}
+	}
+
+	server.out.sendResult(req.ID, server.typeHierarchyItems(supertypes))
+}
+
+// handleTypeHierarchySubtypes processes the 'typeHierarchy/subtypes' request
+func handleTypeHierarchySubtypes(ctx context.Context, server *Server, req RPCRequest) {
+	var params TypeHierarchyParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		server.out.sendError(req.ID, -32602, "Invalid params", nil)
+		return
+	}
+
+	// There is no index by base class, so every tag with an inherits list is checked
+	var subtypes []workspaceEntry
+	err := server.forEachEntry(ctx, func(workspace *Workspace, entry TagEntry) {
+		if entry.Inherits == "" {
+			return
+		}
+		for _, name := range inheritedNames(string(entry.Inherits)) {
+			if name == params.Item.Name {
+				subtypes = append(subtypes, workspaceEntry{workspace, entry})
+				return
+			}
+		}
+	})
+	if err != nil {
+		server.out.sendError(req.ID, -32800, "Request cancelled", nil)
+		return
+	}
+
+	server.out.sendResult(req.ID, server.typeHierarchyItems(subtypes))
+}
+
+// hierarchyEntries returns the tag an item was created from, found by its URI and line,
+// or every type with the item's name if that tag has changed since.
+func (s *Server) hierarchyEntries(ctx context.Context, item TypeHierarchyItem) ([]workspaceEntry, error) {
+	var exact, named []workspaceEntry
+	err := s.forEachNamed(ctx, item.Name, func(workspace *Workspace, entry TagEntry) {
+		uri, _ := relativePathToAbsoluteURI(workspace.rootPath, entry.Path)
+		if uri == item.URI && entry.Line == item.SelectionRange.Start.Line+1 {
+			exact = append(exact, workspaceEntry{workspace, entry})
+		} else if isHierarchyType(entry) {
+			named = append(named, workspaceEntry{workspace, entry})
+		}
+	})
+	if len(exact) > 0 {
+		return exact, err
+	}
+	return named, err
+}
+
+// typeHierarchyItems converts tags to type hierarchy items, dropping duplicates.
+func (s *Server) typeHierarchyItems(types []workspaceEntry) []TypeHierarchyItem {
+	items := []TypeHierarchyItem{}
+	seen := make(map[Location]bool)
+	for _, t := range types {
+		location, ok := s.tagLocation(t.workspace, t.entry)
+		if !ok || seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		kind, err := GetLSPSymbolKind(t.entry.Kind)
+		if err != nil {
+			kind = SymbolKindClass
+		}
+		fullRange := location.Range
+		if lines, err := s.cache.GetOrLoadFileContent(t.workspace.absolutePath(t.entry.Path)); err == nil {
+			fullRange = entryRange(t.entry, lines, location.Range, s.encoding)
+		}
+
+		items = append(items, TypeHierarchyItem{
+			Name:           t.entry.Name,
+			Kind:           kind,
+			Detail:         t.entry.Path,
+			URI:            location.URI,
+			Range:          fullRange,
+			SelectionRange: location.Range,
+		})
+	}
+	return items
+}
+
+// isHierarchyType reports whether an entry can take part in a type hierarchy
+func isHierarchyType(entry TagEntry) bool {
+	return entry.Inherits != "" || isTypeKind(entry.Kind)
+}
+
+// inheritedNames returns the type names of an inherits field such as "Base,public ns::Other<T>".
+// Access specifiers, namespaces and type arguments are dropped, and so are keyword
+// arguments like Python's metaclass=Meta.
+func inheritedNames(inherits string) []string {
+	var names []string
+	for _, base := range strings.Split(inherits, ",") {
+		base, _, _ = strings.Cut(base, "<")
+		base, _, _ = strings.Cut(base, "(")
+		if strings.Contains(base, "=") {
+			continue
+		}
+		fields := strings.Fields(base)
+		if len(fields) == 0 {
+			continue
+		}
+		if name := lastScopeComponent(fields[len(fields)-1]); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,335 @@
+// watch keeps the index in sync with changes made outside the editor. Clients that support
+// it get a dynamically registered 'workspace/didChangeWatchedFiles' watcher; for the others
+// the server can poll file modification times (--poll-interval).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File change types of 'workspace/didChangeWatchedFiles'
+const (
+	fileCreated = 1
+	fileChanged = 2
+	fileDeleted = 3
+)
+
+// watchedFilesRegistrationID identifies the file watcher registration
+const watchedFilesRegistrationID = "ctags-lsp/watched-files"
+
+// WorkspaceClientCapabilities defines the workspace specific client capabilities
+type WorkspaceClientCapabilities struct {
+	DidChangeWatchedFiles *DynamicRegistrationCapabilities `json:"didChangeWatchedFiles,omitempty"`
+}
+
+// DynamicRegistrationCapabilities tells whether a feature can be registered at runtime
+type DynamicRegistrationCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+// RegistrationParams represents the parameters of 'client/registerCapability'
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+// Registration describes a capability to register
+type Registration struct {
+	ID              string `json:"id"`
+	Method          string `json:"method"`
+	RegisterOptions any    `json:"registerOptions,omitempty"`
+}
+
+// DidChangeWatchedFilesRegistrationOptions lists the files the client should watch
+type DidChangeWatchedFilesRegistrationOptions struct {
+	Watchers []FileSystemWatcher `json:"watchers"`
+}
+
+// FileSystemWatcher is a glob pattern of watched files
+type FileSystemWatcher struct {
+	GlobPattern string `json:"globPattern"`
+}
+
+// DidChangeWatchedFilesParams represents the 'workspace/didChangeWatchedFiles' notification
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// FileEvent describes a change to a watched file
+type FileEvent struct {
+	URI  string `json:"uri"`
+	Type int    `json:"type"`
+}
+
+// fileChanges collects the changed and deleted files of a workspace folder
+type fileChanges struct {
+	changed []string
+	deleted []string
+}
+
+// supportsWatchedFiles reports whether the client can register file watchers at runtime.
+func (s *Server) supportsWatchedFiles() bool {
+	workspace := s.capabilities.Workspace
+	return workspace != nil && workspace.DidChangeWatchedFiles != nil && workspace.DidChangeWatchedFiles.DynamicRegistration
+}
+
+// startWatching registers a file watcher with the client, or starts polling if the client
+// can't watch files and polling is enabled.
+func (s *Server) startWatching() {
+	if s.supportsWatchedFiles() {
+		params := RegistrationParams{Registrations: []Registration{{
+			ID:     watchedFilesRegistrationID,
+			Method: "workspace/didChangeWatchedFiles",
+			RegisterOptions: DidChangeWatchedFilesRegistrationOptions{
+				Watchers: []FileSystemWatcher{{GlobPattern: "**/*"}},
+			},
+		}}}
+		ctx, cancel := context.WithTimeout(context.Background(), progressCreateTimeout)
+		_, err := s.out.sendRequest(ctx, "client/registerCapability", params)
+		cancel()
+		if err == nil {
+			return
+		}
+		logWarnf("Failed to register file watcher: %v", err)
+	}
+
+	if interval := s.workspaces.config.pollInterval; interval > 0 {
+		logInfof("Polling for file changes every %s", interval)
+		go s.pollFiles(interval)
+	}
+}
+
+// isVCSPath reports whether a root-relative path lies in a version control directory
+func isVCSPath(rel string) bool {
+	for _, element := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch element {
+		case ".git", ".jj", ".hg", ".svn":
+			return true
+		}
+	}
+	return false
+}
+
+// applyFileChanges updates the index of every workspace folder for files changed on disk.
+func (s *Server) applyFileChanges(changes map[*Workspace]*fileChanges) {
+	configChanged := false
+	for workspace, files := range changes {
+		for _, file := range files.changed {
+			configChanged = configChanged || isProjectConfigFile(file)
+		}
+		for _, file := range files.deleted {
+			configChanged = configChanged || isProjectConfigFile(file)
+		}
+
+		workspace.removeFiles(files.deleted)
+		if err := workspace.rescanFiles(files.changed); err != nil {
+			logErrorf("Error rescanning changed files in %s: %v", workspace.rootPath, err)
+		}
+
+		// Positions are computed from the cached lines, which are outdated now
+		var paths []string
+		for _, file := range files.changed {
+			paths = append(paths, workspace.absolutePath(file))
+		}
+		for _, file := range files.deleted {
+			paths = append(paths, workspace.absolutePath(file))
+		}
+		s.forgetFileContent(paths)
+	}
+
+	if configChanged {
+		s.reindexChangedFolders()
+	}
+}
+
+// forgetFileContent drops the cached lines of files changed on disk, and of every file
+// below the paths that were directories. Documents open in the editor are kept, as their
+// content comes from the client.
+func (s *Server) forgetFileContent(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	open := make(map[string]bool)
+	for _, filePath := range s.openDocuments() {
+		open[filePath] = true
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	for cached := range s.cache.content {
+		if open[cached] {
+			continue
+		}
+		for _, path := range paths {
+			if cached == path || strings.HasPrefix(cached, path+string(filepath.Separator)) {
+				delete(s.cache.content, cached)
+				break
+			}
+		}
+	}
+}
+
+// handleDidChangeWatchedFiles processes the 'workspace/didChangeWatchedFiles' notification
+func handleDidChangeWatchedFiles(server *Server, req RPCRequest) {
+	var params DidChangeWatchedFilesParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return
+	}
+
+	changes := make(map[*Workspace]*fileChanges)
+	for _, event := range params.Changes {
+		workspace, rel, err := server.owningWorkspace(event.URI)
+		if err != nil || isVCSPath(rel) {
+			continue
+		}
+		files, ok := changes[workspace]
+		if !ok {
+			files = &fileChanges{}
+			changes[workspace] = files
+		}
+
+		switch event.Type {
+		case fileDeleted:
+			files.deleted = append(files.deleted, rel)
+		case fileCreated, fileChanged:
+			// Directories are reported too, only files can be tagged
+			if info, err := os.Stat(workspace.absolutePath(rel)); err == nil && !info.IsDir() {
+				files.changed = append(files.changed, rel)
+			}
+		}
+	}
+
+	// The watcher reports every path, only index the files a scan would index
+	for workspace, files := range changes {
+		files.changed = workspace.indexableFiles(files.changed)
+	}
+	server.applyFileChanges(changes)
+}
+
+// indexableFiles keeps the root-relative files that indexing the workspace covers: the
+// files listWorkspaceFiles reports, which leaves out gitignored files, and files already
+// in the index. Project configuration files are kept, so that changes to them are noticed.
+func (w *Workspace) indexableFiles(files []string) []string {
+	if len(files) == 0 {
+		return files
+	}
+	listed, err := listWorkspaceFiles(w.rootPath)
+	if err != nil {
+		logWarnf("Failed to list files of %s: %v", w.rootPath, err)
+		return files
+	}
+	known := make(map[string]bool, len(listed))
+	for _, file := range listed {
+		known[file] = true
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var kept []string
+	for _, file := range files {
+		_, indexed := w.symbols.byFile[file]
+		if known[file] || indexed || isProjectConfigFile(file) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// polledFile is the state of a file seen by the poller
+type polledFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// pollFiles compares the modification times of all workspace files at every interval
+// and updates the index for files that were created, changed or deleted.
+func (s *Server) pollFiles(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	snapshots := make(map[*Workspace]map[string]polledFile)
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		current := make(map[*Workspace]map[string]polledFile)
+		changes := make(map[*Workspace]*fileChanges)
+		for _, workspace := range s.workspaceFolders() {
+			select {
+			case <-workspace.indexed:
+			default:
+				continue // The scan picks up changes until it has finished
+			}
+
+			previous, seen := snapshots[workspace]
+			snapshot, ok := workspace.pollSnapshot()
+			if !ok {
+				// Keep the old snapshot rather than treating every file as deleted
+				if seen {
+					current[workspace] = previous
+				}
+				continue
+			}
+			current[workspace] = snapshot
+
+			files := &fileChanges{}
+			if !seen {
+				// First look at this folder, compare with the index itself
+				for file, state := range snapshot {
+					if !state.modTime.Before(workspace.scanStarted) {
+						files.changed = append(files.changed, file)
+					}
+				}
+				for _, file := range workspace.indexedFiles() {
+					if _, ok := snapshot[file]; !ok {
+						files.deleted = append(files.deleted, file)
+					}
+				}
+			} else {
+				for file, state := range snapshot {
+					if old, ok := previous[file]; !ok || old != state {
+						files.changed = append(files.changed, file)
+					}
+				}
+				for file := range previous {
+					if _, ok := snapshot[file]; !ok {
+						files.deleted = append(files.deleted, file)
+					}
+				}
+			}
+			if len(files.changed) > 0 || len(files.deleted) > 0 {
+				changes[workspace] = files
+			}
+		}
+		snapshots = current
+
+		s.applyFileChanges(changes)
+	}
+}
+
+// pollSnapshot returns the modification time and size of every file of the workspace.
+// It reports false if the files couldn't be listed.
+func (w *Workspace) pollSnapshot() (map[string]polledFile, bool) {
+	snapshot := make(map[string]polledFile)
+	files, err := listWorkspaceFiles(w.rootPath)
+	if err != nil {
+		logWarnf("Failed to list files of %s: %v", w.rootPath, err)
+		return nil, false
+	}
+	for _, file := range files {
+		if w.settings.excluded(file) {
+			continue
+		}
+		if info, err := os.Stat(w.absolutePath(file)); err == nil {
+			snapshot[file] = polledFile{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return snapshot, true
+}
@@ -0,0 +1,427 @@
+// workspace holds the tag index of a workspace folder, the registry that lets several
+// client connections share one index per folder, and the multi-root bookkeeping that
+// maps documents to the folder that owns them.
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkspaceFolder represents a workspace folder as sent by the client
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// DidChangeWorkspaceFoldersParams represents the 'workspace/didChangeWorkspaceFolders' notification
+type DidChangeWorkspaceFoldersParams struct {
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
+// WorkspaceFoldersChangeEvent lists the workspace folders that were added and removed
+type WorkspaceFoldersChangeEvent struct {
+	Added   []WorkspaceFolder `json:"added"`
+	Removed []WorkspaceFolder `json:"removed"`
+}
+
+// Workspace holds the tag index for a single workspace root
+type Workspace struct {
+	rootPath string
+	symbols  symbolStore // guarded by mu
+	settings Settings
+	cacheDir string // directory of the index cache, empty if disabled
+	children processTracker
+	mu       sync.RWMutex
+
+	// Files rescanned or removed while indexing runs, whose entries indexing must not
+	// overwrite. nil once indexing has finished; guarded by mu.
+	rescanned map[string]bool
+
+	progress    indexProgress
+	scanStarted time.Time     // when indexing started, files modified later may be outdated
+	indexed     chan struct{} // closed once indexing has finished
+	indexErr    error         // set before indexed is closed
+	stopped     atomic.Bool   // set when the last connection released the workspace
+}
+
+// newWorkspace creates an empty workspace for rootPath that is indexed with settings.
+func newWorkspace(rootPath string, settings Settings) *Workspace {
+	return &Workspace{
+		rootPath:  rootPath,
+		symbols:   newSymbolStore(),
+		settings:  settings,
+		rescanned: make(map[string]bool),
+		indexed:   make(chan struct{}),
+	}
+}
+
+// absolutePath resolves a root-relative path of this workspace to a filesystem path.
+func (w *Workspace) absolutePath(rel string) string {
+	return filepath.Join(w.rootPath, filepath.FromSlash(rel))
+}
+
+// query runs a symbol store lookup while holding the workspace read lock and calls fn for every
+// entry it yields. Entries of files in the overlay come from the overlay instead of the
+// index. It stops early and returns the context error if the request is cancelled.
+func (w *Workspace) query(ctx context.Context, o *overlay, lookup func(st *symbolStore, visit func(TagEntry) bool), fn func(entry TagEntry)) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	visit := func(entry TagEntry) bool {
+		if isCancelled(ctx) {
+			return false
+		}
+		fn(entry)
+		return true
+	}
+	if o != nil {
+		lookup(&o.symbols, visit)
+	}
+	lookup(&w.symbols, func(entry TagEntry) bool {
+		if o.hides(entry.Path) {
+			return true
+		}
+		return visit(entry)
+	})
+	return ctx.Err()
+}
+
+// forEachEntry calls fn for every tag entry of the workspace.
+func (w *Workspace) forEachEntry(ctx context.Context, o *overlay, fn func(entry TagEntry)) error {
+	return w.query(ctx, o, func(st *symbolStore, visit func(TagEntry) bool) {
+		st.each(visit)
+	}, fn)
+}
+
+// forEachInFile calls fn for every tag entry of a root-relative file.
+func (w *Workspace) forEachInFile(ctx context.Context, o *overlay, path string, fn func(entry TagEntry)) error {
+	return w.query(ctx, o, func(st *symbolStore, visit func(TagEntry) bool) {
+		st.inFile(path, visit)
+	}, fn)
+}
+
+// forEachNamed calls fn for every tag entry with an exact name.
+func (w *Workspace) forEachNamed(ctx context.Context, o *overlay, name string, fn func(entry TagEntry)) error {
+	return w.query(ctx, o, func(st *symbolStore, visit func(TagEntry) bool) {
+		st.named(name, visit)
+	}, fn)
+}
+
+// forEachWithPrefix calls fn for every tag entry whose name starts with prefix, ignoring case.
+func (w *Workspace) forEachWithPrefix(ctx context.Context, o *overlay, prefix string, fn func(entry TagEntry)) error {
+	return w.query(ctx, o, func(st *symbolStore, visit func(TagEntry) bool) {
+		st.withPrefix(prefix, visit)
+	}, fn)
+}
+
+// indexedFiles returns the root-relative paths of all files with tag entries.
+func (w *Workspace) indexedFiles() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return sortedKeys(w.symbols.byFile)
+}
+
+// addEntries adds the entries found while indexing, replacing earlier entries of the same
+// files. Files rescanned or removed since indexing started are skipped, as their entries
+// are newer than the ones indexing found.
+func (w *Workspace) addEntries(entries []TagEntry) {
+	byFile := make(map[string][]TagEntry)
+	for _, entry := range entries {
+		byFile[entry.Path] = append(byFile[entry.Path], entry)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for file, fileEntries := range byFile {
+		if !w.rescanned[file] {
+			w.symbols.replaceFile(file, fileEntries)
+		}
+	}
+}
+
+// markRescanned records files whose entries were updated outside of indexing, so that
+// indexing still running doesn't overwrite them. It must be called with mu held.
+func (w *Workspace) markRescanned(files []string) {
+	if w.rescanned == nil {
+		return // Indexing has finished
+	}
+	for _, file := range files {
+		w.rescanned[file] = true
+	}
+}
+
+// sharedWorkspace is a registry entry with a reference count
+type sharedWorkspace struct {
+	workspace *Workspace
+	refs      int
+}
+
+// workspaceRegistry shares workspace indexes between connections, keyed by root path
+// and settings, so connections with different settings get separate indexes
+type workspaceRegistry struct {
+	mu         sync.Mutex
+	config     *Config
+	cacheDir   string
+	workspaces map[string]*sharedWorkspace
+}
+
+// newWorkspaceRegistry creates a registry whose workspaces default to the settings from config.
+func newWorkspaceRegistry(config *Config) *workspaceRegistry {
+	registry := &workspaceRegistry{
+		config:     config,
+		workspaces: make(map[string]*sharedWorkspace),
+	}
+	if !config.noIndexCache {
+		cacheDir, err := defaultIndexCacheDir()
+		if err != nil {
+			logWarnf("Index cache disabled: %v", err)
+		}
+		registry.cacheDir = cacheDir
+	}
+	return registry
+}
+
+// registryKey identifies a shared workspace
+func registryKey(rootPath string, settings Settings) string {
+	return rootPath + "\x00" + settings.key()
+}
+
+// acquire returns the workspace for rootPath and settings, and starts indexing it in the
+// background if no connection uses it yet. Every acquire must be paired with a release.
+func (r *workspaceRegistry) acquire(rootPath string, settings Settings) *Workspace {
+	key := registryKey(rootPath, settings)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if shared, ok := r.workspaces[key]; ok {
+		shared.refs++
+		return shared.workspace
+	}
+
+	shared := &sharedWorkspace{
+		workspace: newWorkspace(rootPath, settings),
+		refs:      1,
+	}
+	shared.workspace.cacheDir = r.cacheDir
+	r.workspaces[key] = shared
+	go r.index(key, shared)
+	return shared.workspace
+}
+
+// index scans a new workspace. A failed workspace is removed from the registry so the
+// next connection tries again, while current users keep the entries found so far.
+func (r *workspaceRegistry) index(key string, shared *sharedWorkspace) {
+	workspace := shared.workspace
+	workspace.scanStarted = time.Now()
+	workspace.indexErr = workspace.scanWorkspace()
+	workspace.mu.Lock()
+	workspace.rescanned = nil
+	workspace.mu.Unlock()
+	if workspace.indexErr != nil {
+		r.mu.Lock()
+		if r.workspaces[key] == shared {
+			delete(r.workspaces, key)
+		}
+		r.mu.Unlock()
+	}
+	close(workspace.indexed)
+}
+
+// release drops a reference to a workspace. The last release stops its ctags processes
+// and removes it from the registry.
+func (r *workspaceRegistry) release(workspace *Workspace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := registryKey(workspace.rootPath, workspace.settings)
+	shared, ok := r.workspaces[key]
+	if !ok || shared.workspace != workspace {
+		return
+	}
+
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+	delete(r.workspaces, key)
+	workspace.stopped.Store(true)
+	workspace.children.killAll()
+}
+
+// uriToPath converts a file URI to a clean filesystem path. Other strings are treated as paths.
+func uriToPath(uri string) string {
+	if after, ok := strings.CutPrefix(uri, "file://"); ok {
+		uri = filepath.FromSlash(after)
+	}
+	return filepath.Clean(uri)
+}
+
+// workspaceFolders returns the workspace folders of the connection.
+func (s *Server) workspaceFolders() []*Workspace {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	return append([]*Workspace(nil), s.folders...)
+}
+
+// workspaceEntry is a tag entry along with the workspace folder it was found in. Lookups
+// hold the overlay and index locks while they run, so handlers collect the entries and
+// only read files, for locations or doc comments, once the lookup has returned.
+type workspaceEntry struct {
+	workspace *Workspace
+	entry     TagEntry
+}
+
+// forEachFolder calls query for every workspace folder until one returns an error.
+func (s *Server) forEachFolder(query func(workspace *Workspace, o *overlay) error) error {
+	for _, workspace := range s.workspaceFolders() {
+		if err := s.withOverlay(workspace, func(o *overlay) error {
+			return query(workspace, o)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withOverlay calls query with the connection's buffer overlay of a workspace folder,
+// which may be nil, and keeps the overlay from changing until query returns. query must
+// not read open documents through the overlays, such as with bufferLines.
+func (s *Server) withOverlay(workspace *Workspace, query func(o *overlay) error) error {
+	s.buffers.mu.Lock()
+	defer s.buffers.mu.Unlock()
+	return query(s.buffers.roots[workspace.rootPath])
+}
+
+// forEachEntry calls fn for every definition across all workspace folders.
+func (s *Server) forEachEntry(ctx context.Context, fn func(workspace *Workspace, entry TagEntry)) error {
+	return s.forEachFolder(func(workspace *Workspace, o *overlay) error {
+		return workspace.forEachEntry(ctx, o, definitionsOnly(workspace, fn))
+	})
+}
+
+// forEachInFile calls fn for every definition in a root-relative file of a workspace folder.
+func (s *Server) forEachInFile(ctx context.Context, workspace *Workspace, path string, fn func(entry TagEntry)) error {
+	return s.withOverlay(workspace, func(o *overlay) error {
+		return workspace.forEachInFile(ctx, o, path, func(entry TagEntry) {
+			if entry.isDefinition() {
+				fn(entry)
+			}
+		})
+	})
+}
+
+// forEachNamed calls fn for every definition with an exact name across all workspace folders.
+func (s *Server) forEachNamed(ctx context.Context, name string, fn func(workspace *Workspace, entry TagEntry)) error {
+	return s.forEachFolder(func(workspace *Workspace, o *overlay) error {
+		return workspace.forEachNamed(ctx, o, name, definitionsOnly(workspace, fn))
+	})
+}
+
+// forEachWithPrefix calls fn for every definition whose name starts with prefix, ignoring
+// case, across all workspace folders.
+func (s *Server) forEachWithPrefix(ctx context.Context, prefix string, fn func(workspace *Workspace, entry TagEntry)) error {
+	return s.forEachFolder(func(workspace *Workspace, o *overlay) error {
+		return workspace.forEachWithPrefix(ctx, o, prefix, definitionsOnly(workspace, fn))
+	})
+}
+
+// forEachReferenceTag calls fn for every reference tag with an exact name across all
+// workspace folders. ctags only outputs reference tags with --extras=+r.
+func (s *Server) forEachReferenceTag(ctx context.Context, name string, fn func(workspace *Workspace, entry TagEntry)) error {
+	return s.forEachFolder(func(workspace *Workspace, o *overlay) error {
+		return workspace.forEachNamed(ctx, o, name, func(entry TagEntry) {
+			if !entry.isDefinition() {
+				fn(workspace, entry)
+			}
+		})
+	})
+}
+
+// definitionsOnly adapts fn to the entries of workspace, skipping reference tags.
+func definitionsOnly(workspace *Workspace, fn func(workspace *Workspace, entry TagEntry)) func(entry TagEntry) {
+	return func(entry TagEntry) {
+		if entry.isDefinition() {
+			fn(workspace, entry)
+		}
+	}
+}
+
+// owningWorkspace returns the workspace folder containing a document and the document's
+// path relative to that folder. Nested folders resolve to the innermost one.
+func (s *Server) owningWorkspace(uri string) (*Workspace, string, error) {
+	path := uriToPath(uri)
+
+	var owner *Workspace
+	var ownerRel string
+	for _, workspace := range s.workspaceFolders() {
+		rel, err := toRootRelativePath(workspace.rootPath, path)
+		if err != nil {
+			continue
+		}
+		if owner == nil || len(workspace.rootPath) > len(owner.rootPath) {
+			owner = workspace
+			ownerRel = rel
+		}
+	}
+
+	if owner == nil {
+		return nil, "", fmt.Errorf("path outside workspace folders: %q", path)
+	}
+	return owner, ownerRel, nil
+}
+
+// addWorkspaceFolder acquires the index for a folder and adds it to the connection.
+// It returns nil if the folder is already part of the connection.
+func (s *Server) addWorkspaceFolder(rootPath string) *Workspace {
+	settings := s.settingsFor(rootPath)
+
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	for _, workspace := range s.folders {
+		if workspace.rootPath == rootPath {
+			return nil
+		}
+	}
+
+	workspace := s.workspaces.acquire(rootPath, settings)
+	s.folders = append(s.folders, workspace)
+	return workspace
+}
+
+// removeWorkspaceFolder removes a folder from the connection and releases its index.
+func (s *Server) removeWorkspaceFolder(rootPath string) {
+	s.foldersMu.Lock()
+	var removed *Workspace
+	for i, workspace := range s.folders {
+		if workspace.rootPath == rootPath {
+			removed = workspace
+			s.folders = append(s.folders[:i:i], s.folders[i+1:]...)
+			break
+		}
+	}
+	s.foldersMu.Unlock()
+
+	if removed != nil {
+		s.buffers.mu.Lock()
+		delete(s.buffers.roots, rootPath)
+		s.buffers.mu.Unlock()
+		s.workspaces.release(removed)
+	}
+}
+
+// releaseWorkspaces gives up the connection's references to its workspace folders.
+// The folders stay readable for requests that are still in flight.
+func (s *Server) releaseWorkspaces() {
+	s.releaseOnce.Do(func() {
+		close(s.closed)
+		for _, workspace := range s.workspaceFolders() {
+			s.workspaces.release(workspace)
+		}
+	})
+}